@@ -0,0 +1,340 @@
+package shell
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	jobOutputChunk = 64 << 10
+)
+
+type BackgroundJob struct {
+	ID      int
+	PID     int
+	Command string
+	Running bool
+	Status  int
+}
+
+type background struct {
+	mutex     sync.Mutex
+	directory string
+	jobs      []BackgroundJob
+}
+
+// Background runs the command detached from the session with its output in
+// a file of a temporary directory, so it can be inspected and killed by
+// follow-up commands
+func (shell *shell) Background(command string) (int, error) {
+	if err := Validate(command); err != nil {
+		return -1, shell.redaction.error(err)
+	}
+
+	directory, err := shell.jobDirectory()
+	if err != nil {
+		return -1, err
+	}
+
+	shell.background.mutex.Lock()
+	id := len(shell.background.jobs) + 1
+	shell.background.jobs = append(shell.background.jobs, BackgroundJob{
+		ID:      id,
+		Command: shell.redaction.line(command),
+		Running: true,
+		Status:  -1,
+	})
+	shell.background.mutex.Unlock()
+
+	output, status := jobFiles(directory, id)
+	lines, err := shell.capture(
+		"( (\n" + strings.TrimRight(command, "\n") + "\n) " +
+			"> " + Quote(output) + " 2>&1 < /dev/null; " +
+			"echo $? > " + Quote(status+".tmp") + " && " +
+			"mv " + Quote(status+".tmp") + " " + Quote(status) + " " +
+			") > /dev/null 2>&1 < /dev/null & echo $!",
+	)
+
+	if err == nil {
+		var pid int
+		pid, err = lastNumber(lines)
+
+		shell.background.mutex.Lock()
+		shell.background.jobs[id-1].PID = pid
+		shell.background.mutex.Unlock()
+	}
+
+	if err != nil {
+		shell.background.mutex.Lock()
+		shell.background.jobs[id-1].Running = false
+		shell.background.mutex.Unlock()
+		return -1, err
+	}
+
+	return id, nil
+}
+
+func (shell *shell) Jobs() ([]BackgroundJob, error) {
+	shell.background.mutex.Lock()
+	count := len(shell.background.jobs)
+	shell.background.mutex.Unlock()
+
+	jobs := []BackgroundJob{}
+	for id := 1; id <= count; id++ {
+		job, err := shell.JobStatus(id)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+func (shell *shell) JobStatus(id int) (BackgroundJob, error) {
+	job, directory, err := shell.job(id)
+	if err != nil || !job.Running || job.PID == 0 {
+		return job, err
+	}
+
+	_, status := jobFiles(directory, id)
+	lines, err := shell.capture(
+		"if [ -f " + Quote(status) + " ]; then cat " + Quote(status) + "; " +
+			"elif kill -0 " + strconv.Itoa(job.PID) + " 2> /dev/null; " +
+			"then echo running; else echo lost; fi",
+	)
+
+	if err != nil {
+		return job, err
+	}
+
+	if len(lines) == 0 {
+		return job, fmt.Errorf("shell: no status for job %d", id)
+	}
+
+	switch lines[len(lines)-1] {
+	case "running":
+		return job, nil
+	case "lost":
+		job.Running = false
+	default:
+		job.Running = false
+		job.Status, err = strconv.Atoi(lines[len(lines)-1])
+		if err != nil {
+			return job, err
+		}
+	}
+
+	shell.background.mutex.Lock()
+	shell.background.jobs[id-1] = job
+	shell.background.mutex.Unlock()
+
+	return job, nil
+}
+
+// JobOutput returns output of the job starting from offset along with the
+// offset to continue from; output is returned in chunks of whole lines while
+// the job runs, so it is read until the offset stops moving
+func (shell *shell) JobOutput(id int, offset int64) (string, int64, error) {
+	job, err := shell.JobStatus(id)
+	if err != nil {
+		return "", offset, err
+	}
+
+	_, directory, err := shell.job(id)
+	if err != nil {
+		return "", offset, err
+	}
+
+	output, _ := jobFiles(directory, id)
+	lines, err := shell.capture(
+		"tail -c +" + strconv.FormatInt(offset+1, 10) + " " + Quote(output) +
+			" | head -c " + strconv.Itoa(jobOutputChunk) + " | od -An -v -tx1",
+	)
+
+	if err != nil {
+		return "", offset, err
+	}
+
+	data, err := hex.DecodeString(strings.Join(strings.Fields(
+		strings.Join(lines, " "),
+	), ""))
+
+	if err != nil {
+		return "", offset, err
+	}
+
+	// a line which is still arriving is left for the next read so a secret
+	// is never split between reads; the status is taken before the output so
+	// the output of a finished job is complete
+	content := string(data)
+	if job.Running || len(data) == jobOutputChunk {
+		if newline := strings.LastIndexByte(content, '\n'); newline != -1 {
+			content = content[:newline+1]
+		} else if len(data) < jobOutputChunk {
+			content = ""
+		} else if cut := shell.redaction.cut(content, len(content)); cut > 0 {
+			content = content[:cut]
+		}
+	}
+
+	redacted := strings.Split(content, "\n")
+	for index, line := range redacted {
+		redacted[index] = shell.redaction.line(line)
+	}
+
+	return strings.Join(redacted, "\n"), offset + int64(len(content)), nil
+}
+
+// KillJob terminates processes of the job but not the wrapper which records
+// the exit status; the tree is collected first as children of a killed
+// process are moved away from it
+func (shell *shell) KillJob(id int) error {
+	job, _, err := shell.job(id)
+	if err != nil || !job.Running || job.PID == 0 {
+		return err
+	}
+
+	_, err = shell.capture(
+		"__shell_tree() { " +
+			"for __shell_child in $(pgrep -P \"$1\"); do " +
+			"echo \"$__shell_child\"; __shell_tree \"$__shell_child\"; " +
+			"done; }; " +
+			"kill -TERM $(__shell_tree " + strconv.Itoa(job.PID) + ") " +
+			"2> /dev/null; :",
+	)
+
+	return err
+}
+
+// backgroundPIDs returns the jobs which are not known to be finished; they are
+// children of the interpreter as well and are left out when the foreground
+// command is signalled
+func (shell *shell) backgroundPIDs() []int {
+	shell.background.mutex.Lock()
+	defer shell.background.mutex.Unlock()
+
+	result := []int{}
+	for _, job := range shell.background.jobs {
+		if job.Running && job.PID != 0 {
+			result = append(result, job.PID)
+		}
+	}
+
+	return result
+}
+
+func (shell *shell) job(id int) (BackgroundJob, string, error) {
+	shell.background.mutex.Lock()
+	defer shell.background.mutex.Unlock()
+
+	if id < 1 || id > len(shell.background.jobs) {
+		return BackgroundJob{}, "", fmt.Errorf("shell: unknown job %d", id)
+	}
+
+	return shell.background.jobs[id-1], shell.background.directory, nil
+}
+
+func (shell *shell) jobDirectory() (string, error) {
+	shell.background.mutex.Lock()
+	directory := shell.background.directory
+	shell.background.mutex.Unlock()
+
+	if directory != "" {
+		return directory, nil
+	}
+
+	lines, err := shell.capture("mktemp -d")
+	if err != nil {
+		return "", err
+	}
+
+	if len(lines) == 0 {
+		return "", fmt.Errorf("shell: failed to create job directory")
+	}
+
+	shell.background.mutex.Lock()
+	defer shell.background.mutex.Unlock()
+
+	if shell.background.directory == "" {
+		shell.background.directory = lines[len(lines)-1]
+	}
+
+	return shell.background.directory, nil
+}
+
+// cleanup returns the command which removes the job directory; it is run by
+// the interpreter as the directory may be on another host
+func (shell *shell) cleanup() string {
+	shell.background.mutex.Lock()
+	defer shell.background.mutex.Unlock()
+
+	if shell.background.directory == "" {
+		return ""
+	}
+
+	return "rm -rf " + Quote(shell.background.directory) + "\n"
+}
+
+func jobFiles(directory string, id int) (string, string) {
+	prefix := directory + "/" + strconv.Itoa(id)
+	return prefix + ".out", prefix + ".status"
+}
+
+// capture runs a follow-up command and returns its stdout, any failure or
+// cut output is an error since the result is parsed
+func (shell *shell) capture(command string) ([]string, error) {
+	stdout := []string{}
+	stderr := []string{}
+	handler := func(kind MessageType, line string) error {
+		if kind == StdOut {
+			stdout = append(stdout, line)
+		} else {
+			stderr = append(stderr, line)
+		}
+
+		return nil
+	}
+
+	// the output limit is meant for the commands of the caller, the output
+	// of follow-up commands is bounded by the commands themselves
+	query := command + "\n" + epilogue
+	result, err := shell.execute(command, query, handler, func(<-chan struct{}) error {
+		shell.unlimited = true
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Truncated {
+		return nil, fmt.Errorf(
+			"shell: output of %q is truncated",
+			shell.redaction.line(command),
+		)
+	}
+
+	if result.Status != 0 {
+		return nil, fmt.Errorf(
+			"shell: %q failed with status %d: %s",
+			shell.redaction.line(command),
+			result.Status,
+			strings.Join(stderr, "\n"),
+		)
+	}
+
+	return stdout, nil
+}
+
+func lastNumber(lines []string) (int, error) {
+	if len(lines) == 0 {
+		return -1, fmt.Errorf("shell: no output")
+	}
+
+	return strconv.Atoi(strings.TrimSpace(lines[len(lines)-1]))
+}
@@ -0,0 +1,247 @@
+package shell
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitTestBackgroundJob(test *testing.T, shell *Local, id int) BackgroundJob {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := shell.JobStatus(id)
+		assert.NoError(test, err)
+		if !job.Running {
+			return job
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	test.Fatalf("job %d is still running", id)
+	return BackgroundJob{}
+}
+
+func TestBackgroundRunsJob(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	id, err := state.shell.Background("echo OUT; echo ERR 1>&2; exit 3")
+	assert.NoError(test, err)
+	assert.Equal(test, 1, id)
+
+	job := waitTestBackgroundJob(test, state.shell, id)
+	assert.Equal(test, 3, job.Status)
+	assert.Greater(test, job.PID, 0)
+	assert.Equal(test, "echo OUT; echo ERR 1>&2; exit 3", job.Command)
+
+	output, offset, err := state.shell.JobOutput(id, 0)
+	assert.NoError(test, err)
+	assert.Equal(test, "OUT\nERR\n", output)
+	assert.Equal(test, int64(len("OUT\nERR\n")), offset)
+
+	output, offset, err = state.shell.JobOutput(id, 4)
+	assert.NoError(test, err)
+	assert.Equal(test, "ERR\n", output)
+	assert.Equal(test, int64(8), offset)
+}
+
+func TestBackgroundKeepsSessionUsable(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	_, err := state.shell.Background("sleep 100")
+	assert.NoError(test, err)
+
+	status, err := state.shell.Run("echo ALIVE", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"OUT: ALIVE"}, state.args)
+}
+
+func TestBackgroundJobSurvivesSignalToCommand(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	id, err := state.shell.Background("sleep 100")
+	assert.NoError(test, err)
+
+	go func() {
+		for {
+			pids, _ := descendants(state.shell.pid, state.shell.backgroundPIDs()...)
+			if len(pids) > 0 {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		state.shell.Signal(syscall.SIGTERM)
+	}()
+
+	status, err := state.shell.Run("sleep 100", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 143, status)
+
+	job, err := state.shell.JobStatus(id)
+	assert.NoError(test, err)
+	assert.True(test, job.Running)
+	assert.NoError(test, state.shell.KillJob(id))
+}
+
+func TestBackgroundKillsJob(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	id, err := state.shell.Background("echo STARTED; sleep 100")
+	assert.NoError(test, err)
+
+	job, err := state.shell.JobStatus(id)
+	assert.NoError(test, err)
+	assert.True(test, job.Running)
+
+	for {
+		output, _, err := state.shell.JobOutput(id, 0)
+		assert.NoError(test, err)
+		if output != "" {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.NoError(test, state.shell.KillJob(id))
+
+	job = waitTestBackgroundJob(test, state.shell, id)
+	assert.Equal(test, 143, job.Status)
+}
+
+func TestBackgroundListsJobs(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	first, _ := state.shell.Background("true")
+	second, _ := state.shell.Background("sleep 100")
+	defer state.shell.KillJob(second)
+
+	waitTestBackgroundJob(test, state.shell, first)
+
+	jobs, err := state.shell.Jobs()
+	assert.NoError(test, err)
+	assert.Len(test, jobs, 2)
+	assert.False(test, jobs[0].Running)
+	assert.Equal(test, 0, jobs[0].Status)
+	assert.True(test, jobs[1].Running)
+	assert.Equal(test, -1, jobs[1].Status)
+}
+
+func TestBackgroundReadsLargeOutputInChunks(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	id, err := state.shell.Background("seq 1 20000")
+	assert.NoError(test, err)
+	waitTestBackgroundJob(test, state.shell, id)
+
+	output := ""
+	offset := int64(0)
+	for {
+		chunk, next, err := state.shell.JobOutput(id, offset)
+		assert.NoError(test, err)
+		if next == offset {
+			break
+		}
+
+		output += chunk
+		offset = next
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	assert.Len(test, lines, 20000)
+	assert.Equal(test, "20000", lines[19999])
+}
+
+func TestBackgroundIgnoresOutputLimit(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{
+		OutputLimit: OutputLimit{Lines: 2, Policy: FailOnLimit},
+	})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	id, err := shell.Background("seq 1 100")
+	assert.NoError(test, err)
+	waitTestBackgroundJob(test, shell, id)
+
+	chunk, _, err := shell.JobOutput(id, 0)
+	assert.NoError(test, err)
+	assert.Len(test, strings.Split(strings.TrimSpace(chunk), "\n"), 100)
+
+	_, err = shell.Run("seq 1 3", nil)
+	assert.ErrorIs(test, err, ErrOutputLimit)
+}
+
+func TestBackgroundRedactsOutput(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{Redact: []string{"s3cr3t"}})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	id, err := shell.Background("echo s3cr3t")
+	assert.NoError(test, err)
+	job := waitTestBackgroundJob(test, shell, id)
+	assert.Equal(test, "echo ***", job.Command)
+
+	output, _, err := shell.JobOutput(id, 0)
+	assert.NoError(test, err)
+	assert.Equal(test, "***\n", output)
+}
+
+func TestBackgroundRedactsOutputOnLineBoundaries(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{Redact: []string{"s3cr3t"}})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	id, err := shell.Background("echo status; printf s3c; sleep 0.3; echo r3t")
+	assert.NoError(test, err)
+
+	for {
+		output, offset, err := shell.JobOutput(id, 0)
+		assert.NoError(test, err)
+		if output != "" {
+			assert.Equal(test, "status\n", output)
+			assert.Equal(test, int64(7), offset)
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	waitTestBackgroundJob(test, shell, id)
+	output, offset, err := shell.JobOutput(id, 7)
+	assert.NoError(test, err)
+	assert.Equal(test, "***\n", output)
+	assert.Equal(test, int64(14), offset)
+}
+
+func TestBackgroundRemovesJobDirectoryOnClose(test *testing.T) {
+	state := newTestLocalState()
+
+	_, err := state.shell.Background("true")
+	assert.NoError(test, err)
+
+	directory := state.shell.background.directory
+	assert.DirExists(test, directory)
+
+	assert.NoError(test, state.shell.Close())
+	assert.NoDirExists(test, directory)
+}
+
+func TestBackgroundReturnsErrorOnUnknownJob(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	_, err := state.shell.JobStatus(1)
+	assert.EqualError(test, err, "shell: unknown job 1")
+}
@@ -0,0 +1,154 @@
+package shell
+
+import (
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type DryRunConfig struct {
+	Shell  Shell
+	Allow  []*regexp.Regexp
+	Status func(command string) int
+	Logger *slog.Logger
+
+	Redact     []string
+	RedactFunc func(string) string
+}
+
+type DryRunCommand struct {
+	Command  string
+	Executed bool
+}
+
+// DryRun records commands instead of running them; commands whose every
+// simple command matches Allow are still run by Shell, so read-only checks
+// give real answers
+type DryRun struct {
+	shell     Shell
+	allow     []*regexp.Regexp
+	status    func(command string) int
+	log       *slog.Logger
+	redaction redactor
+
+	mutex    sync.Mutex
+	commands []DryRunCommand
+}
+
+func NewDryRun(config DryRunConfig) *DryRun {
+	shell := &DryRun{
+		shell:     config.Shell,
+		allow:     config.Allow,
+		status:    config.Status,
+		log:       config.Logger,
+		redaction: newRedactor(config.Redact, config.RedactFunc),
+	}
+
+	if shell.log == nil {
+		shell.log = slog.New(slog.DiscardHandler)
+	}
+
+	return shell
+}
+
+func (shell *DryRun) Run(
+	command string,
+	handler func(MessageType, string) error,
+) (int, error) {
+	if err := Validate(command); err != nil {
+		return -1, shell.redaction.error(err)
+	}
+
+	executed := shell.shell != nil && shell.allowed(command)
+
+	shell.mutex.Lock()
+	shell.commands = append(shell.commands, DryRunCommand{
+		Command:  shell.redaction.line(command),
+		Executed: executed,
+	})
+	shell.mutex.Unlock()
+
+	shell.log.Info(
+		"shell: dry run",
+		"command", shell.redaction.line(command),
+		"executed", executed,
+	)
+
+	if executed {
+		return shell.shell.Run(command, handler)
+	}
+
+	if shell.status == nil {
+		return 0, nil
+	}
+
+	return shell.status(command), nil
+}
+
+// allowed tells whether every simple command matches Allow; a command with
+// substitutions, writing redirections or an expanded executable is never run
+// since the patterns can not tell what it does
+func (shell *DryRun) allowed(command string) bool {
+	lexer := newLexer(command, 0, false)
+	if err := lexer.run(); err != nil || len(lexer.substitutions) > 0 {
+		return false
+	}
+
+	list := invocations(lexer.tokens)
+	if len(list) == 0 {
+		return false
+	}
+
+	for _, invocation := range list {
+		if len(invocation.targets) > 0 || len(invocation.words) == 0 ||
+			invocation.words[0].expanded {
+			return false
+		}
+
+		words := []string{}
+		for _, word := range invocation.words {
+			words = append(words, word.raw)
+		}
+
+		if !shell.match(strings.Join(words, " ")) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (shell *DryRun) match(command string) bool {
+	for _, pattern := range shell.allow {
+		if pattern.MatchString(command) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (shell *DryRun) Commands() []DryRunCommand {
+	shell.mutex.Lock()
+	defer shell.mutex.Unlock()
+
+	return append([]DryRunCommand{}, shell.commands...)
+}
+
+func (shell *DryRun) Signal(signal os.Signal) error {
+	if shell.shell == nil {
+		return nil
+	}
+
+	return shell.shell.Signal(signal)
+}
+
+func (shell *DryRun) Close() error {
+	if shell.shell == nil {
+		return nil
+	}
+
+	return shell.shell.Close()
+}
@@ -0,0 +1,124 @@
+package shell
+
+import (
+	"bytes"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRunRecordsCommands(test *testing.T) {
+	shell := NewDryRun(DryRunConfig{})
+
+	status, err := shell.Run("rm -rf /srv/app", func(MessageType, string) error {
+		test.Fatal("handler is called")
+		return nil
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(
+		test,
+		[]DryRunCommand{{Command: "rm -rf /srv/app", Executed: false}},
+		shell.Commands(),
+	)
+}
+
+func TestDryRunReturnsConfiguredStatus(test *testing.T) {
+	shell := NewDryRun(DryRunConfig{Status: func(command string) int {
+		if strings.HasPrefix(command, "test ") {
+			return 1
+		}
+
+		return 0
+	}})
+
+	status, _ := shell.Run("test -e /srv/app", nil)
+	assert.Equal(test, 1, status)
+
+	status, _ = shell.Run("mkdir /srv/app", nil)
+	assert.Equal(test, 0, status)
+}
+
+func TestDryRunPassesAllowedCommandsThrough(test *testing.T) {
+	state := newTestLocalState()
+	shell := NewDryRun(DryRunConfig{
+		Shell: state.shell,
+		Allow: []*regexp.Regexp{regexp.MustCompile(`^echo `)},
+	})
+
+	defer shell.Close()
+
+	status, err := shell.Run("echo REAL", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"OUT: REAL"}, state.args)
+
+	status, err = shell.Run("false", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+
+	assert.Equal(test, []DryRunCommand{
+		{Command: "echo REAL", Executed: true},
+		{Command: "false", Executed: false},
+	}, shell.Commands())
+}
+
+func TestDryRunRecordsChainedCommandsWithoutRunningThem(test *testing.T) {
+	state := newTestLocalState()
+	shell := NewDryRun(DryRunConfig{
+		Shell: state.shell,
+		Allow: []*regexp.Regexp{regexp.MustCompile(`^(test|cat|echo) `)},
+	})
+
+	defer shell.Close()
+
+	commands := []string{
+		"echo SAFE; echo RUN > /dev/null; touch " + test.TempDir() + "/file",
+		"echo $(echo RUN)",
+		"echo SAFE && touch file",
+		"cat x | sh",
+		"echo RUN > file",
+		"$COMMAND file",
+	}
+
+	for _, command := range commands {
+		status, err := shell.Run(command, state.handler)
+		assert.NoError(test, err)
+		assert.Equal(test, 0, status)
+	}
+
+	assert.Empty(test, state.args)
+	for _, command := range shell.Commands() {
+		assert.False(test, command.Executed, command.Command)
+	}
+
+	status, err := shell.Run("echo A; echo B | cat -", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"OUT: A", "OUT: B"}, state.args)
+}
+
+func TestDryRunLogsRedactedCommands(test *testing.T) {
+	output := &bytes.Buffer{}
+	shell := NewDryRun(DryRunConfig{
+		Logger: slog.New(slog.NewTextHandler(output, nil)),
+		Redact: []string{"s3cr3t"},
+	})
+
+	shell.Run("curl -u admin:s3cr3t example.com", nil)
+	assert.Contains(test, output.String(), `command="curl -u admin:*** example.com"`)
+	assert.Equal(test, "curl -u admin:*** example.com", shell.Commands()[0].Command)
+}
+
+func TestDryRunRejectsIncompleteCommand(test *testing.T) {
+	shell := NewDryRun(DryRunConfig{})
+	_, err := shell.Run(`echo "TEST`, nil)
+
+	var syntaxErr *SyntaxError
+	assert.ErrorAs(test, err, &syntaxErr)
+	assert.Empty(test, shell.Commands())
+}
@@ -0,0 +1,292 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	expectBufferSize = 64 * 1024
+
+	// expectEnd stops the relay of responses; it does nothing if it reaches
+	// the interpreter
+	expectEnd = ": __shell_expect_end"
+
+	expectFailed = "__SHELL_EXPECT_FAILED__"
+)
+
+var (
+	ErrExpectSetup = errors.New("shell: failed to set up stdin for expect")
+)
+
+// Expectation waits for Pattern in the output of either stream including a
+// line which has no newline yet, like a prompt, and answers with Response or
+// calls Respond with the redacted match to write to stdin of the command;
+// Timeout limits the time the pattern is waited for after the previous
+// expectation is met
+type Expectation struct {
+	Pattern  *regexp.Regexp
+	Response string
+	Respond  func(match []string, stdin io.Writer) error
+	Timeout  time.Duration
+}
+
+type ExpectError struct {
+	Pattern string
+	Timeout time.Duration
+	Err     error
+}
+
+func (err *ExpectError) Error() string {
+	if err.Err != nil {
+		return fmt.Sprintf(
+			"shell: response to %q failed: %s",
+			err.Pattern,
+			err.Err,
+		)
+	}
+
+	return fmt.Sprintf(
+		"shell: %q is not seen in %s",
+		err.Pattern,
+		err.Timeout,
+	)
+}
+
+func (err *ExpectError) Unwrap() error {
+	return err.Err
+}
+
+// expecter matches expectations in order against the output of a single
+// command; it is fed by the stream readers
+type expecter struct {
+	shell        *shell
+	expectations []Expectation
+
+	mutex    sync.Mutex
+	current  int
+	pending  map[MessageType]string
+	finished map[MessageType]bool
+	timer    *time.Timer
+	err      error
+	ended    bool
+	done     <-chan struct{}
+}
+
+// Expect runs command answering its prompts; expectations are met in order
+// and the ones not seen until the command finishes are ignored. Responses are
+// passed to the command by lines and the ones it does not read are dropped.
+func (shell *shell) Expect(
+	command string,
+	expectations []Expectation,
+	handler func(MessageType, string) error,
+) (int, error) {
+	if err := Validate(command); err != nil {
+		return -1, shell.redaction.error(err)
+	}
+
+	expecter := &expecter{
+		shell:        shell,
+		expectations: expectations,
+		pending:      map[MessageType]string{},
+		finished:     map[MessageType]bool{},
+	}
+
+	failed := false
+	setup := func(kind MessageType, line string) error {
+		if kind == StdErr && line == expectFailed {
+			failed = true
+			return nil
+		}
+
+		if handler == nil {
+			return nil
+		}
+
+		return handler(kind, line)
+	}
+
+	query := expectQuery(command)
+	result, err := shell.execute(command, query, setup, func(
+		done <-chan struct{},
+	) error {
+		expecter.mutex.Lock()
+		defer expecter.mutex.Unlock()
+
+		expecter.done = done
+		shell.expecter.Store(expecter)
+		expecter.arm()
+		return nil
+	})
+
+	if expectErr := expecter.stop(); expectErr != nil && err == nil {
+		err = expectErr
+	}
+
+	if failed {
+		return -1, ErrExpectSetup
+	}
+
+	return int(result.Status), err
+}
+
+// expectQuery gives the command its own stdin, a fifo fed by a relay which
+// reads stdin of the interpreter by lines until the end line is written once
+// the command finishes; a response the command does not read is dropped then
+// rather than run by the interpreter. The query is read by the interpreter as
+// a whole so stdin is left for the relay. When the fifo can not be made the
+// command is not run and responses are read and dropped by the interpreter.
+func expectQuery(command string) string {
+	fifo := "\"$__shell_expect/stdin\""
+	lines := "while IFS= read -r __shell_line && " +
+		"[ \"$__shell_line\" != '" + expectEnd + "' ]; do "
+	relay := "( trap '' PIPE; " + lines +
+		"printf '%s\\n' \"$__shell_line\"; done > " + fifo + " ) 2> /dev/null"
+
+	return "__shell_relay=; " +
+		"if __shell_expect=$(mktemp -d) && mkfifo " + fifo + "; then " +
+		"{ " + relay + " <&9 & } 9<&0; __shell_relay=$!; " +
+		"{ " + strings.TrimRight(command, "\n") + "\n} < " + fifo + "; " +
+		"else printf '%s\\n' " + expectFailed + " 1>&2; false; fi; " +
+		strings.TrimSuffix(epilogue, "\n") + "; " +
+		"if [ -n \"$__shell_relay\" ]; then wait \"$__shell_relay\"; " +
+		"else " + lines + ":; done; fi; " +
+		"[ -z \"$__shell_expect\" ] || rm -rf \"$__shell_expect\"\n"
+}
+
+// arm starts the timer of the current expectation, the mutex is held
+func (expecter *expecter) arm() {
+	if expecter.timer != nil {
+		expecter.timer.Stop()
+		expecter.timer = nil
+	}
+
+	if expecter.current >= len(expecter.expectations) || expecter.err != nil {
+		return
+	}
+
+	expectation := expecter.expectations[expecter.current]
+	if expectation.Timeout <= 0 {
+		return
+	}
+
+	current := expecter.current
+	expecter.timer = time.AfterFunc(expectation.Timeout, func() {
+		expecter.mutex.Lock()
+		if expecter.current != current || expecter.err != nil {
+			expecter.mutex.Unlock()
+			return
+		}
+
+		expecter.err = &ExpectError{
+			Pattern: expectation.Pattern.String(),
+			Timeout: expectation.Timeout,
+		}
+
+		err := expecter.err
+		expecter.mutex.Unlock()
+		expecter.shell.interruptCommand(err, expecter.done)
+	})
+}
+
+func (expecter *expecter) stop() error {
+	expecter.mutex.Lock()
+	defer expecter.mutex.Unlock()
+
+	if expecter.timer != nil {
+		expecter.timer.Stop()
+	}
+
+	expecter.current = len(expecter.expectations)
+	return expecter.err
+}
+
+// feed matches output of a stream as it arrives; the output after the exit
+// status marker and the marker which is still arriving are not matched
+func (expecter *expecter) feed(kind MessageType, data string) {
+	expecter.mutex.Lock()
+	defer expecter.mutex.Unlock()
+
+	if expecter.finished[kind] {
+		return
+	}
+
+	pending := expecter.pending[kind] + data
+	if location := exitStatusRegexp.FindStringIndex(pending); location != nil {
+		pending = pending[:location[0]]
+		expecter.finished[kind] = true
+	}
+
+	content := pending
+	if status := partialStatus(pending); status != -1 {
+		content = pending[:status]
+	}
+
+	offset := 0
+	for expecter.current < len(expecter.expectations) && expecter.err == nil {
+		expectation := expecter.expectations[expecter.current]
+		location := expectation.Pattern.FindStringSubmatchIndex(content[offset:])
+		if location == nil {
+			break
+		}
+
+		match := []string{}
+		for index := 0; index < len(location); index += 2 {
+			if location[index] < 0 {
+				match = append(match, "")
+				continue
+			}
+
+			start, end := offset+location[index], offset+location[index+1]
+			group := content[start:end]
+			match = append(match, expecter.shell.redaction.line(group))
+		}
+
+		offset += location[1]
+		if err := expecter.respond(expectation, match); err != nil {
+			expecter.err = &ExpectError{
+				Pattern: expectation.Pattern.String(),
+				Err:     err,
+			}
+
+			go expecter.shell.interruptCommand(expecter.err, expecter.done)
+			break
+		}
+
+		expecter.current++
+		expecter.arm()
+	}
+
+	// only the last line which is still arriving may hold a prompt
+	pending = pending[offset:]
+	if newline := strings.LastIndexByte(pending, '\n'); newline != -1 {
+		pending = pending[newline+1:]
+	}
+
+	if len(pending) > expectBufferSize {
+		pending = pending[len(pending)-expectBufferSize:]
+	}
+
+	expecter.pending[kind] = pending
+
+	// responses are written before the end so the relay passes them first
+	if expecter.finished[StdOut] && expecter.finished[StdErr] && !expecter.ended {
+		expecter.ended = true
+		io.WriteString(expecter.shell.stdin, "\n"+expectEnd+"\n")
+	}
+}
+
+func (expecter *expecter) respond(expectation Expectation, match []string) error {
+	stdin := expecter.shell.stdin
+	if expectation.Respond != nil {
+		return expectation.Respond(match, stdin)
+	}
+
+	_, err := io.WriteString(stdin, expectation.Response)
+	return err
+}
@@ -0,0 +1,239 @@
+package shell
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpectAnswersPartialLine(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	written := make(chan string, 2)
+	go func() {
+		for {
+			data := make([]byte, 1024)
+			count, err := state.stdin.Read(data)
+			if err != nil {
+				return
+			}
+
+			written <- string(data[:count])
+		}
+	}()
+
+	go func() {
+		<-written
+		state.stdout.Write([]byte("Password: "))
+		<-written
+		state.stdout.Write([]byte("\n__SHELL_EXIT_STATUS_0__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+	}()
+
+	status, err := state.shell.Expect(
+		"COMMAND",
+		[]Expectation{{
+			Pattern:  regexp.MustCompile(`Password: $`),
+			Response: "secret\n",
+		}},
+		state.handler,
+	)
+
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"OUT: Password: "}, state.args)
+}
+
+func TestExpectWritesQueryOnOneLine(test *testing.T) {
+	query := make(chan string, 1)
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	go func() {
+		data := make([]byte, 1024)
+		count, _ := state.stdin.Read(data)
+		query <- string(data[:count])
+		state.stdout.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+		io.Copy(io.Discard, state.stdin)
+	}()
+
+	_, err := state.shell.Expect("COMMAND\n", nil, nil)
+	assert.NoError(test, err)
+
+	written := <-query
+	assert.Equal(test, expectQuery("COMMAND"), written)
+	assert.Contains(test, written, "{ COMMAND\n} < \"$__shell_expect/stdin\"; ")
+	assert.Equal(test, 2, strings.Count(written, "\n"))
+}
+
+func TestLocalExpectAnswersPrompts(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	command := "printf 'Continue? [y/N] '; read answer; " +
+		"printf 'Name for %s: ' \"$answer\"; read name; echo \"$name\""
+
+	status, err := state.shell.Expect(
+		command,
+		[]Expectation{
+			{
+				Pattern:  regexp.MustCompile(`Continue\? \[y/N\] $`),
+				Response: "y\n",
+				Timeout:  5 * time.Second,
+			},
+			{
+				Pattern: regexp.MustCompile(`Name for (\w+): $`),
+				Respond: func(match []string, stdin io.Writer) error {
+					_, err := fmt.Fprintf(stdin, "NAME-%s\n", match[1])
+					return err
+				},
+				Timeout: 5 * time.Second,
+			},
+		},
+		state.handler,
+	)
+
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(
+		test,
+		[]string{"OUT: Continue? [y/N] Name for y: NAME-y"},
+		state.args,
+	)
+
+	state.args = nil
+	state.shell.Run("echo ALIVE", state.handler)
+	assert.Equal(test, []string{"OUT: ALIVE"}, state.args)
+}
+
+func TestLocalExpectTimesOut(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	started := time.Now()
+	status, err := state.shell.Expect(
+		"echo WAITING; sleep 30",
+		[]Expectation{{
+			Pattern:  regexp.MustCompile(`never`),
+			Response: "y\n",
+			Timeout:  100 * time.Millisecond,
+		}},
+		state.handler,
+	)
+
+	assert.Less(test, time.Since(started), 5*time.Second)
+	assert.Equal(test, 130, status)
+	assert.Equal(test, []string{"OUT: WAITING"}, state.args)
+
+	var expectErr *ExpectError
+	assert.ErrorAs(test, err, &expectErr)
+	assert.Equal(test, `shell: "never" is not seen in 100ms`, err.Error())
+
+	status, err = state.shell.Run("echo ALIVE", nil)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+}
+
+func TestLocalExpectIgnoresOutputOfOtherCommands(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	_, err := state.shell.Expect("true", []Expectation{{
+		Pattern:  regexp.MustCompile(`PROMPT`),
+		Response: "echo INJECTED\n",
+	}}, nil)
+
+	assert.NoError(test, err)
+
+	state.shell.Run("echo PROMPT", state.handler)
+	assert.Equal(test, []string{"OUT: PROMPT"}, state.args)
+}
+
+func TestLocalExpectDropsUnreadResponses(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	status, err := state.shell.Expect("printf 'Continue? '; sleep 0.2", []Expectation{{
+		Pattern:  regexp.MustCompile(`Continue\? $`),
+		Response: "echo INJECTED\n",
+	}}, nil)
+
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+
+	status, err = state.shell.Run("echo NEXT", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"OUT: NEXT"}, state.args)
+}
+
+func TestLocalExpectPassesResponsesToCommandOnly(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	status, err := state.shell.Expect("printf 'Name: '; read name; echo \"$name\"", []Expectation{{
+		Pattern:  regexp.MustCompile(`Name: $`),
+		Response: "first\nsecond\n",
+	}}, state.handler)
+
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"OUT: Name: first"}, state.args)
+
+	state.args = nil
+	status, err = state.shell.Run("echo NEXT", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"OUT: NEXT"}, state.args)
+}
+
+func TestLocalExpectRedactsMatch(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{Redact: []string{"s3cr3t"}})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	matches := [][]string{}
+	_, err = shell.Expect("printf 'pw s3cr3t? '; read answer", []Expectation{{
+		Pattern: regexp.MustCompile(`pw (\S+)\? $`),
+		Respond: func(match []string, stdin io.Writer) error {
+			matches = append(matches, match)
+			_, err := io.WriteString(stdin, "\n")
+			return err
+		},
+	}}, nil)
+
+	assert.NoError(test, err)
+	assert.Equal(test, [][]string{{"pw ***? ", "***"}}, matches)
+}
+
+func TestLocalExpectFailsWithoutFifo(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	_, err := state.shell.Run("export TMPDIR=/nonexistent", nil)
+	assert.NoError(test, err)
+
+	status, err := state.shell.Expect(
+		"echo RAN",
+		[]Expectation{{
+			Pattern:  regexp.MustCompile(`EXPECT`),
+			Response: "echo INJECTED\n",
+		}},
+		nil,
+	)
+
+	assert.ErrorIs(test, err, ErrExpectSetup)
+	assert.Equal(test, -1, status)
+
+	status, err = state.shell.Run("unset TMPDIR; echo DONE", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"OUT: DONE"}, state.args)
+}
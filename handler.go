@@ -0,0 +1,135 @@
+package shell
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"time"
+)
+
+var (
+	// ErrStopped is returned by StopOn handler; the command output is still
+	// drained so the session can run the next command
+	ErrStopped = errors.New("shell: stopped on match")
+)
+
+// WriteTo writes lines of each stream to its writer; a nil writer discards
+// the stream
+func WriteTo(
+	stdout io.Writer,
+	stderr io.Writer,
+) func(MessageType, string) error {
+	return func(kind MessageType, line string) error {
+		writer := stdout
+		if kind == StdErr {
+			writer = stderr
+		} else if kind != StdOut {
+			return nil
+		}
+
+		if writer == nil {
+			return nil
+		}
+
+		_, err := io.WriteString(writer, line+"\n")
+		return err
+	}
+}
+
+// Collect appends lines of each stream to its slice; a nil slice skips the
+// stream
+func Collect(stdout *[]string, stderr *[]string) func(MessageType, string) error {
+	return func(kind MessageType, line string) error {
+		if kind == StdOut && stdout != nil {
+			*stdout = append(*stdout, line)
+		} else if kind == StdErr && stderr != nil {
+			*stderr = append(*stderr, line)
+		}
+
+		return nil
+	}
+}
+
+// CollectLines appends lines of both streams to lines keeping their order
+func CollectLines(lines *[]Line) func(MessageType, string) error {
+	return func(kind MessageType, line string) error {
+		if kind == StdOut || kind == StdErr {
+			*lines = append(*lines, Line{kind, line})
+		}
+
+		return nil
+	}
+}
+
+// StopOn passes lines to handler until one matches pattern; the matching line
+// is passed as well and then ErrStopped is returned
+func StopOn(
+	pattern *regexp.Regexp,
+	handler func(MessageType, string) error,
+) func(MessageType, string) error {
+	return func(kind MessageType, line string) error {
+		if handler != nil {
+			if err := handler(kind, line); err != nil {
+				return err
+			}
+		}
+
+		if (kind == StdOut || kind == StdErr) && pattern.MatchString(line) {
+			return ErrStopped
+		}
+
+		return nil
+	}
+}
+
+// Prefix prepends prefix, for example a host name, to every line
+func Prefix(
+	prefix string,
+	handler func(MessageType, string) error,
+) func(MessageType, string) error {
+	return func(kind MessageType, line string) error {
+		if kind == StdOut || kind == StdErr {
+			line = prefix + line
+		}
+
+		if handler == nil {
+			return nil
+		}
+
+		return handler(kind, line)
+	}
+}
+
+// Timestamp prepends the time a line is received formatted with layout and
+// a space to every line
+func Timestamp(
+	layout string,
+	handler func(MessageType, string) error,
+) func(MessageType, string) error {
+	return func(kind MessageType, line string) error {
+		if kind == StdOut || kind == StdErr {
+			line = time.Now().Format(layout) + " " + line
+		}
+
+		if handler == nil {
+			return nil
+		}
+
+		return handler(kind, line)
+	}
+}
+
+// Tee passes every line to all handlers and returns the first error; the
+// remaining handlers still get the line
+func Tee(handlers ...func(MessageType, string) error) func(MessageType, string) error {
+	return func(kind MessageType, line string) error {
+		var result error
+		for _, handler := range handlers {
+			if err := handler(kind, line); err != nil && result == nil {
+				result = err
+			}
+		}
+
+		return result
+	}
+}
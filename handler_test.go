@@ -0,0 +1,134 @@
+package shell
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runHandlerTest(
+	state testShellState,
+	handler func(MessageType, string) error,
+	stdout string,
+	stderr string,
+) (int, error) {
+	go io.Copy(io.Discard, state.stdin)
+	go func() {
+		state.stdout.Write([]byte(stdout + "__SHELL_EXIT_STATUS_0__"))
+		state.stderr.Write([]byte(stderr + "__SHELL_EXIT_STATUS_0__"))
+	}()
+
+	return state.shell.Run("COMMAND", handler)
+}
+
+func TestWriteToWritesStreams(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	_, err := runHandlerTest(state, WriteTo(stdout, stderr), "1\n2\n", "3\n")
+
+	assert.NoError(test, err)
+	assert.Equal(test, "1\n2\n", stdout.String())
+	assert.Equal(test, "3\n", stderr.String())
+}
+
+func TestCollectCollectsStreams(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	stdout := []string{}
+	lines := []Line{}
+	_, err := runHandlerTest(
+		state,
+		Tee(Collect(&stdout, nil), CollectLines(&lines)),
+		"1\n2\n",
+		"3\n",
+	)
+
+	assert.NoError(test, err)
+	assert.Equal(test, []string{"1", "2"}, stdout)
+	assert.ElementsMatch(
+		test,
+		[]Line{{StdOut, "1"}, {StdOut, "2"}, {StdErr, "3"}},
+		lines,
+	)
+}
+
+func TestStopOnStopsAndDrains(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	stdout := []string{}
+	_, err := runHandlerTest(
+		state,
+		StopOn(regexp.MustCompile(`^READY`), Collect(&stdout, nil)),
+		"starting\nREADY 1\nafter\n",
+		"",
+	)
+
+	assert.ErrorIs(test, err, ErrStopped)
+	assert.Equal(test, []string{"starting", "READY 1"}, stdout)
+
+	stdout = nil
+	_, err = runHandlerTest(state, Collect(&stdout, nil), "NEXT\n", "")
+	assert.NoError(test, err)
+	assert.Equal(test, []string{"NEXT"}, stdout)
+}
+
+func TestPrefixAndTimestampPrependToLines(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	lines := []Line{}
+	_, err := runHandlerTest(
+		state,
+		Timestamp(time.RFC3339, Prefix("web-1: ", CollectLines(&lines))),
+		"1\n",
+		"",
+	)
+
+	assert.NoError(test, err)
+	assert.Len(test, lines, 1)
+	assert.True(test, strings.HasPrefix(lines[0].Text, "web-1: "))
+	assert.True(test, strings.HasSuffix(lines[0].Text, " 1"))
+
+	stamp := strings.TrimSuffix(strings.TrimPrefix(lines[0].Text, "web-1: "), " 1")
+	_, err = time.Parse(time.RFC3339, stamp)
+	assert.NoError(test, err)
+}
+
+func TestTeeCallsEveryHandler(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	failure := errors.New("failure")
+	first := []string{}
+	second := []string{}
+	_, err := runHandlerTest(
+		state,
+		Tee(
+			func(MessageType, string) error { return failure },
+			Collect(&first, nil),
+			Collect(&second, nil),
+		),
+		"1\n",
+		"",
+	)
+
+	assert.Equal(test, failure, err)
+	assert.Equal(test, []string{"1"}, first)
+	assert.Equal(test, []string{"1"}, second)
+}
+
+func TestPrefixAndTimestampAcceptNilHandler(test *testing.T) {
+	assert.NoError(test, Prefix("x", nil)(StdOut, "1"))
+	assert.NoError(test, Timestamp(time.RFC3339, nil)(StdOut, "1"))
+}
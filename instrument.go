@@ -0,0 +1,176 @@
+package shell
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	CommandsCounter   = "shell.commands"
+	ErrorsCounter     = "shell.command.errors"
+	FailuresCounter   = "shell.command.failures"
+	DurationHistogram = "shell.command.duration"
+)
+
+type Span struct {
+	Command     string
+	Host        string
+	Status      int
+	Err         error
+	Start       time.Time
+	Duration    time.Duration
+	StdOutBytes int
+	StdErrBytes int
+}
+
+type Exporter interface {
+	ExportSpan(span Span)
+	AddCounter(name string, delta int64, labels map[string]string)
+	RecordHistogram(name string, value float64, labels map[string]string)
+}
+
+type InstrumentConfig struct {
+	Host     string
+	Exporter Exporter
+
+	Redact     []string
+	RedactFunc func(string) string
+}
+
+type Instrumented struct {
+	shell     Shell
+	host      string
+	exporter  Exporter
+	redaction redactor
+}
+
+func NewInstrumented(shell Shell, config InstrumentConfig) *Instrumented {
+	return &Instrumented{
+		shell:     shell,
+		host:      config.Host,
+		exporter:  config.Exporter,
+		redaction: newRedactor(config.Redact, config.RedactFunc),
+	}
+}
+
+func (shell *Instrumented) Run(
+	command string,
+	handler func(MessageType, string) error,
+) (int, error) {
+	span := Span{
+		Command: shell.redaction.line(command),
+		Host:    shell.host,
+		Start:   time.Now(),
+	}
+
+	status, err := shell.shell.Run(command, func(
+		kind MessageType,
+		line string,
+	) error {
+		if kind == StdOut {
+			span.StdOutBytes += len(line) + 1
+		} else if kind == StdErr {
+			span.StdErrBytes += len(line) + 1
+		}
+
+		if handler == nil {
+			return nil
+		}
+
+		return handler(kind, line)
+	})
+
+	span.Duration = time.Since(span.Start)
+	span.Status = status
+	span.Err = shell.redaction.error(err)
+
+	labels := map[string]string{"host": shell.host}
+	shell.exporter.ExportSpan(span)
+	shell.exporter.AddCounter(CommandsCounter, 1, labels)
+	if err != nil {
+		shell.exporter.AddCounter(ErrorsCounter, 1, labels)
+	} else if status != 0 {
+		shell.exporter.AddCounter(FailuresCounter, 1, labels)
+	}
+
+	shell.exporter.RecordHistogram(
+		DurationHistogram,
+		span.Duration.Seconds(),
+		labels,
+	)
+
+	return status, err
+}
+
+func (shell *Instrumented) Signal(signal os.Signal) error {
+	return shell.shell.Signal(signal)
+}
+
+func (shell *Instrumented) Close() error {
+	return shell.shell.Close()
+}
+
+type MemoryExporter struct {
+	mutex      sync.Mutex
+	spans      []Span
+	counters   map[string]int64
+	histograms map[string][]float64
+}
+
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{
+		counters:   map[string]int64{},
+		histograms: map[string][]float64{},
+	}
+}
+
+func (exporter *MemoryExporter) ExportSpan(span Span) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	exporter.spans = append(exporter.spans, span)
+}
+
+func (exporter *MemoryExporter) AddCounter(
+	name string,
+	delta int64,
+	labels map[string]string,
+) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	exporter.counters[name] += delta
+}
+
+func (exporter *MemoryExporter) RecordHistogram(
+	name string,
+	value float64,
+	labels map[string]string,
+) {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	exporter.histograms[name] = append(exporter.histograms[name], value)
+}
+
+func (exporter *MemoryExporter) Spans() []Span {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	return append([]Span{}, exporter.spans...)
+}
+
+func (exporter *MemoryExporter) Counter(name string) int64 {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	return exporter.counters[name]
+}
+
+func (exporter *MemoryExporter) Histogram(name string) []float64 {
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	return append([]float64{}, exporter.histograms[name]...)
+}
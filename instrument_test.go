@@ -0,0 +1,73 @@
+package shell
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestInstrumented(test *testing.T) (*Instrumented, *MemoryExporter) {
+	local, err := NewLocal(LocalConfig{})
+	assert.NoError(test, err)
+
+	exporter := NewMemoryExporter()
+	return NewInstrumented(local, InstrumentConfig{
+		Host:     "localhost",
+		Exporter: exporter,
+		Redact:   []string{"s3cr3t"},
+	}), exporter
+}
+
+func TestInstrumentedExportsSpan(test *testing.T) {
+	shell, exporter := newTestInstrumented(test)
+	defer shell.Close()
+
+	lines := []string{}
+	status, err := shell.Run("echo s3cr3t; echo ERR 1>&2; (exit 2)", func(
+		kind MessageType,
+		line string,
+	) error {
+		lines = append(lines, line)
+		return nil
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, 2, status)
+	assert.ElementsMatch(test, []string{"s3cr3t", "ERR"}, lines)
+
+	spans := exporter.Spans()
+	assert.Len(test, spans, 1)
+	assert.Equal(test, "echo ***; echo ERR 1>&2; (exit 2)", spans[0].Command)
+	assert.Equal(test, "localhost", spans[0].Host)
+	assert.Equal(test, 2, spans[0].Status)
+	assert.NoError(test, spans[0].Err)
+	assert.Equal(test, len("s3cr3t\n"), spans[0].StdOutBytes)
+	assert.Equal(test, len("ERR\n"), spans[0].StdErrBytes)
+	assert.Greater(test, int64(spans[0].Duration), int64(0))
+}
+
+func TestInstrumentedCountsCommands(test *testing.T) {
+	shell, exporter := newTestInstrumented(test)
+	defer shell.Close()
+
+	shell.Run("true", nil)
+	shell.Run("false", nil)
+	shell.Run(`echo "s3cr3t`, nil)
+
+	assert.Equal(test, int64(3), exporter.Counter(CommandsCounter))
+	assert.Equal(test, int64(1), exporter.Counter(FailuresCounter))
+	assert.Equal(test, int64(1), exporter.Counter(ErrorsCounter))
+	assert.Len(test, exporter.Histogram(DurationHistogram), 3)
+
+	spans := exporter.Spans()
+	assert.Error(test, spans[2].Err)
+	assert.NotContains(test, spans[2].Err.Error(), "s3cr3t")
+}
+
+func TestInstrumentedPassesSignalToShell(test *testing.T) {
+	shell, _ := newTestInstrumented(test)
+	defer shell.Close()
+
+	assert.NoError(test, shell.Signal(syscall.SIGINT))
+}
@@ -0,0 +1,194 @@
+package shell
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrCanceled = errors.New("shell: job canceled before start")
+
+	errJobCanceled = errors.New("shell: job canceled")
+)
+
+type Line struct {
+	Type MessageType
+	Text string
+}
+
+const (
+	jobPending = iota
+	jobRunning
+	jobFinished
+)
+
+// Job is a command started in background; its output is kept until it is
+// read from Lines. Once Lines is called the output kept is limited to the
+// buffer size of the session and the command waits for the reader like it
+// waits for a slow handler; before that nothing waits so the job completes
+// whether its output is read or not
+type Job struct {
+	shell *shell
+
+	mutex   sync.Mutex
+	state   int
+	lines   []Line
+	bytes   int
+	running <-chan struct{}
+	changed chan struct{}
+	stream  chan Line
+	pump    sync.Once
+	reading bool
+
+	done   chan struct{}
+	result Result
+	err    error
+}
+
+func (shell *shell) Start(command string) (*Job, error) {
+	if err := Validate(command); err != nil {
+		return nil, shell.redaction.error(err)
+	}
+
+	select {
+	case <-shell.closing:
+		return nil, ErrClosed
+	default:
+	}
+
+	job := &Job{
+		shell:   shell,
+		changed: make(chan struct{}),
+		stream:  make(chan Line),
+		done:    make(chan struct{}),
+	}
+
+	query := strings.TrimRight(command, "\n") + "\n" + epilogue
+	go func() {
+		result, err := shell.execute(command, query, job.add, job.begin)
+
+		job.mutex.Lock()
+		job.state = jobFinished
+		job.result = result
+		job.err = err
+		job.notify()
+		job.mutex.Unlock()
+
+		close(job.done)
+	}()
+
+	return job, nil
+}
+
+func (job *Job) begin(running <-chan struct{}) error {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	if job.state != jobPending {
+		return ErrCanceled
+	}
+
+	job.state = jobRunning
+	job.running = running
+	return nil
+}
+
+func (job *Job) add(kind MessageType, text string) error {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+
+	for job.reading && job.bytes > 0 &&
+		job.bytes+len(text) > job.shell.messages.size {
+		changed := job.changed
+		job.mutex.Unlock()
+
+		select {
+		case <-changed:
+			job.mutex.Lock()
+		case <-job.shell.closing:
+			job.mutex.Lock()
+			return ErrClosed
+		}
+	}
+
+	job.lines = append(job.lines, Line{kind, text})
+	job.bytes += len(text)
+	job.notify()
+	return nil
+}
+
+func (job *Job) notify() {
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+func (job *Job) Lines() <-chan Line {
+	job.pump.Do(func() {
+		job.mutex.Lock()
+		job.reading = true
+		job.mutex.Unlock()
+
+		go job.forward()
+	})
+
+	return job.stream
+}
+
+func (job *Job) forward() {
+	for {
+		job.mutex.Lock()
+		changed := job.changed
+		finished := job.state == jobFinished
+		lines := job.lines
+		job.lines = nil
+		if len(lines) > 0 {
+			job.bytes = 0
+			job.notify()
+		}
+		job.mutex.Unlock()
+
+		for _, line := range lines {
+			job.stream <- line
+		}
+
+		if len(lines) > 0 {
+			continue
+		}
+
+		if finished {
+			close(job.stream)
+			return
+		}
+
+		<-changed
+	}
+}
+
+func (job *Job) Wait() (int, error) {
+	<-job.done
+	return int(job.result.Status), job.err
+}
+
+func (job *Job) Done() <-chan struct{} {
+	return job.done
+}
+
+// Cancel interrupts the command if it is running, until it finishes since it
+// may be not started yet, and keeps it from running if it still waits for the
+// interpreter
+func (job *Job) Cancel() error {
+	job.mutex.Lock()
+	state := job.state
+	running := job.running
+	if state == jobPending {
+		job.state = jobFinished
+	}
+	job.mutex.Unlock()
+
+	if state == jobRunning {
+		go job.shell.interruptCommand(errJobCanceled, running)
+	}
+
+	return nil
+}
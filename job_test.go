@@ -0,0 +1,196 @@
+package shell
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobStreamsLines(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	job, err := state.shell.Start("echo 1; echo 2 1>&2; echo 3; (exit 4)")
+	assert.NoError(test, err)
+
+	lines := []Line{}
+	for line := range job.Lines() {
+		lines = append(lines, line)
+	}
+
+	assert.ElementsMatch(
+		test,
+		[]Line{{StdOut, "1"}, {StdErr, "2"}, {StdOut, "3"}},
+		lines,
+	)
+
+	status, err := job.Wait()
+	assert.NoError(test, err)
+	assert.Equal(test, 4, status)
+}
+
+func TestJobCompletesWithoutReadingLines(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	job, err := state.shell.Start("seq 1 10000")
+	assert.NoError(test, err)
+
+	select {
+	case <-job.Done():
+	case <-time.After(5 * time.Second):
+		test.Fatal("job is not done")
+	}
+
+	count := 0
+	for range job.Lines() {
+		count += 1
+	}
+
+	assert.Equal(test, 10000, count)
+}
+
+func TestJobCancelInterruptsCommand(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	job, err := state.shell.Start("echo STARTED; sleep 100")
+	assert.NoError(test, err)
+
+	line := <-job.Lines()
+	assert.Equal(test, Line{StdOut, "STARTED"}, line)
+
+	for {
+		pids, _ := descendants(state.shell.pid)
+		if len(pids) > 0 {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.NoError(test, job.Cancel())
+
+	status, err := job.Wait()
+	assert.NoError(test, err)
+	assert.Equal(test, 130, status)
+}
+
+func TestJobCancelInterruptsCommandWhichIsStarting(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	job, err := state.shell.Start("sleep 3")
+	assert.NoError(test, err)
+
+	for {
+		job.mutex.Lock()
+		state := job.state
+		job.mutex.Unlock()
+		if state != jobPending {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	started := time.Now()
+	assert.NoError(test, job.Cancel())
+
+	status, err := job.Wait()
+	assert.NoError(test, err)
+	assert.Equal(test, 130, status)
+	assert.Less(test, time.Since(started), 2*time.Second)
+}
+
+func TestJobCompletesWithoutReadingLinesOverBufferSize(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{BufferSize: 1024})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	job, err := shell.Start("seq 1 10000")
+	assert.NoError(test, err)
+
+	select {
+	case <-job.Done():
+	case <-time.After(5 * time.Second):
+		test.Fatal("job is not done")
+	}
+
+	status, err := job.Wait()
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+
+	status, err = shell.Run("true", nil)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+}
+
+func TestJobBoundsUnreadLines(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{BufferSize: 1024})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	job, err := shell.Start("echo STARTED; sleep 0.1; seq 1 10000")
+	assert.NoError(test, err)
+
+	lines := job.Lines()
+	assert.Equal(test, Line{StdOut, "STARTED"}, <-lines)
+
+	time.Sleep(300 * time.Millisecond)
+	job.mutex.Lock()
+	assert.LessOrEqual(test, job.bytes, 1024)
+	job.mutex.Unlock()
+
+	count := 0
+	for range lines {
+		count += 1
+	}
+
+	status, err := job.Wait()
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, 10000, count)
+}
+
+func TestJobCancelPreventsPendingCommand(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	first, err := state.shell.Start("echo STARTED; sleep 0.2")
+	assert.NoError(test, err)
+	<-first.Lines()
+
+	second, err := state.shell.Start("echo NEVER")
+	assert.NoError(test, err)
+	assert.NoError(test, second.Cancel())
+
+	_, err = second.Wait()
+	assert.ErrorIs(test, err, ErrCanceled)
+
+	status, err := first.Wait()
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+
+	for line := range second.Lines() {
+		test.Fatalf("unexpected line %v", line)
+	}
+}
+
+func TestJobRejectsIncompleteCommand(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	_, err := state.shell.Start(`echo "TEST`)
+	var syntaxErr *SyntaxError
+	assert.ErrorAs(test, err, &syntaxErr)
+}
+
+func TestJobReturnsErrClosedAfterClose(test *testing.T) {
+	state := newTestLocalState()
+	state.shell.Close()
+
+	_, err := state.shell.Start("echo TEST")
+	assert.ErrorIs(test, err, ErrClosed)
+}
@@ -0,0 +1,891 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+type SyntaxError struct {
+	Command string
+	Offset  int
+	Reason  string
+}
+
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf(
+		"shell: syntax error at offset %d: %s in %q",
+		err.Offset,
+		err.Reason,
+		err.Command,
+	)
+}
+
+func Validate(command string) error {
+	_, err := lex(command)
+	return err
+}
+
+type tokenKind int
+
+const (
+	wordToken tokenKind = iota
+	operatorToken
+)
+
+type token struct {
+	kind     tokenKind
+	value    string
+	raw      string
+	quoted   bool
+	expanded bool
+	offset   int
+}
+
+const (
+	caseWord = iota
+	caseIn
+	casePattern
+	caseBody
+)
+
+type frame struct {
+	closer string
+	offset int
+	state  int
+}
+
+type heredoc struct {
+	delimiter string
+	strip     bool
+	expand    bool
+	offset    int
+}
+
+var (
+	operators = []string{
+		"&>>", ";;&", "<<<", "<<-",
+		"&&", "||", ";;", ";&", "<<", ">>", "<&", ">&", "<>", ">|", "&>", "|&",
+		"&", "|", ";", "<", ">", "(", ")",
+	}
+
+	redirections = map[string]bool{
+		"<": true, ">": true, ">>": true, "<&": true, ">&": true, "<>": true,
+		">|": true, "&>": true, "&>>": true, "<<": true, "<<-": true,
+		"<<<": true,
+	}
+
+	controls = map[string]bool{
+		"&&": true, "||": true, "|": true, "|&": true, ";": true, "&": true,
+	}
+)
+
+type lexer struct {
+	input  string
+	offset int
+	nested bool
+	tokens []token
+
+	frames   []frame
+	heredocs []heredoc
+
+	start    bool
+	redirect bool
+	function bool
+	heredoc  *heredoc
+
+	boundaries []int
+
+	expanded      bool
+	substitutions []string
+}
+
+func lex(input string) ([]token, error) {
+	lexer := newLexer(input, 0, false)
+	err := lexer.run()
+	return lexer.tokens, err
+}
+
+func newLexer(input string, offset int, nested bool) *lexer {
+	return &lexer{input: input, offset: offset, nested: nested, start: true}
+}
+
+func (lexer *lexer) run() error {
+	for {
+		lexer.skipBlanks()
+		if lexer.offset >= len(lexer.input) {
+			if err := lexer.finish(); err != nil {
+				return err
+			}
+
+			if lexer.nested {
+				return lexer.error(len(lexer.input), "unterminated command "+
+					"substitution")
+			}
+
+			return nil
+		}
+
+		char := lexer.input[lexer.offset]
+		switch {
+		case char == '#':
+			end := strings.IndexByte(lexer.input[lexer.offset:], '\n')
+			if end == -1 {
+				lexer.offset = len(lexer.input)
+			} else {
+				lexer.offset += end
+			}
+		case char == '\n':
+			continued := lexer.continued()
+			lexer.offset++
+			if err := lexer.operator("\n", "", lexer.offset-1); err != nil {
+				return err
+			}
+
+			if err := lexer.readHeredocs(); err != nil {
+				return err
+			}
+
+			if !continued && len(lexer.frames) == 0 {
+				lexer.boundaries = append(lexer.boundaries, lexer.offset)
+			}
+		case strings.IndexByte(";&|<>()", char) != -1 && !lexer.process():
+			offset := lexer.offset
+			operator := lexer.matchOperator()
+			if operator == ")" && lexer.nested && len(lexer.frames) == 0 {
+				return lexer.finishNested(offset)
+			}
+
+			if err := lexer.operator(operator, "", offset); err != nil {
+				return err
+			}
+		default:
+			token, err := lexer.word()
+			if err != nil {
+				return err
+			}
+
+			if lexer.isNumber(token) {
+				offset := lexer.offset
+				operator := lexer.matchOperator()
+				err = lexer.operator(operator, token.raw, offset)
+			} else {
+				err = lexer.handleWord(token)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (lexer *lexer) error(
+	offset int,
+	format string,
+	args ...interface{},
+) error {
+	return &SyntaxError{
+		Command: lexer.input,
+		Offset:  offset,
+		Reason:  fmt.Sprintf(format, args...),
+	}
+}
+
+func (lexer *lexer) skipBlanks() {
+	for lexer.offset < len(lexer.input) {
+		switch {
+		case lexer.input[lexer.offset] == ' ' || lexer.input[lexer.offset] == '\t':
+			lexer.offset++
+		case strings.HasPrefix(lexer.input[lexer.offset:], "\\\n"):
+			lexer.offset += 2
+		default:
+			return
+		}
+	}
+}
+
+func (lexer *lexer) matchOperator() string {
+	for _, operator := range operators {
+		if strings.HasPrefix(lexer.input[lexer.offset:], operator) {
+			lexer.offset += len(operator)
+			return operator
+		}
+	}
+
+	panic("unreachable")
+}
+
+func (lexer *lexer) isNumber(token token) bool {
+	if token.quoted || lexer.offset >= len(lexer.input) {
+		return false
+	}
+
+	if lexer.input[lexer.offset] != '<' && lexer.input[lexer.offset] != '>' {
+		return false
+	}
+
+	return strings.Trim(token.raw, "0123456789") == ""
+}
+
+func (lexer *lexer) top() *frame {
+	if len(lexer.frames) == 0 {
+		return nil
+	}
+
+	return &lexer.frames[len(lexer.frames)-1]
+}
+
+func (lexer *lexer) push(closer string, offset int) {
+	lexer.frames = append(lexer.frames, frame{closer: closer, offset: offset})
+}
+
+func (lexer *lexer) pop(closer string, offset int) error {
+	top := lexer.top()
+	if top == nil || top.closer != closer {
+		return lexer.error(offset, "unexpected %q", closer)
+	}
+
+	lexer.frames = lexer.frames[:len(lexer.frames)-1]
+	return nil
+}
+
+func (lexer *lexer) expect(closer string, word string, offset int) error {
+	top := lexer.top()
+	if top == nil || top.closer != closer {
+		return lexer.error(offset, "unexpected %q", word)
+	}
+
+	return nil
+}
+
+// continued tells whether a command goes on after the newline: a pipeline or
+// a list is not finished or a function name waits for its body
+func (lexer *lexer) continued() bool {
+	count := len(lexer.tokens)
+	if count == 0 || lexer.tokens[count-1].kind != operatorToken {
+		return false
+	}
+
+	switch lexer.tokens[count-1].value {
+	case "&&", "||", "|", "|&":
+		return true
+	case ")":
+		return count >= 2 && lexer.tokens[count-2].value == "(" &&
+			lexer.tokens[count-2].kind == operatorToken
+	}
+
+	return false
+}
+
+func (lexer *lexer) previous() *token {
+	if len(lexer.tokens) == 0 {
+		return nil
+	}
+
+	return &lexer.tokens[len(lexer.tokens)-1]
+}
+
+func (lexer *lexer) operator(operator string, prefix string, offset int) error {
+	previous := lexer.previous()
+	if lexer.heredoc != nil || lexer.redirect {
+		return lexer.error(offset, "unexpected %q", operator)
+	}
+
+	if controls[operator] && (previous == nil ||
+		previous.kind == operatorToken && (controls[previous.value] ||
+			previous.value == "\n" || previous.value == "(")) {
+		return lexer.error(offset, "unexpected %q", operator)
+	}
+
+	lexer.tokens = append(lexer.tokens, token{
+		kind:   operatorToken,
+		value:  prefix + operator,
+		raw:    prefix + operator,
+		offset: offset,
+	})
+
+	top := lexer.top()
+	inPattern := top != nil && top.closer == "esac" && top.state == casePattern
+
+	switch {
+	case redirections[operator]:
+		lexer.redirect = true
+		if operator == "<<" || operator == "<<-" {
+			lexer.heredoc = &heredoc{strip: operator == "<<-", offset: offset}
+		}
+	case operator == "(":
+		if !inPattern {
+			lexer.push(")", offset)
+		}
+
+		lexer.start = true
+	case operator == ")":
+		if inPattern {
+			top.state = caseBody
+		} else if err := lexer.pop(")", offset); err != nil {
+			return err
+		}
+
+		lexer.start = true
+	case operator == ";;" || operator == ";&" || operator == ";;&":
+		if top == nil || top.closer != "esac" || top.state != caseBody {
+			return lexer.error(offset, "unexpected %q", operator)
+		}
+
+		top.state = casePattern
+		lexer.start = true
+	default:
+		lexer.start = true
+	}
+
+	return nil
+}
+
+func (lexer *lexer) handleWord(token token) error {
+	lexer.tokens = append(lexer.tokens, token)
+
+	if lexer.heredoc != nil {
+		lexer.heredoc.delimiter = token.value
+		lexer.heredoc.expand = !token.quoted
+		lexer.heredocs = append(lexer.heredocs, *lexer.heredoc)
+		lexer.heredoc = nil
+		lexer.redirect = false
+		return nil
+	}
+
+	if lexer.redirect {
+		lexer.redirect = false
+		return nil
+	}
+
+	if lexer.function {
+		lexer.function = false
+		lexer.start = true
+		return nil
+	}
+
+	keyword := ""
+	if !token.quoted {
+		keyword = token.raw
+	}
+
+	top := lexer.top()
+	if top != nil && top.closer == "esac" {
+		switch top.state {
+		case caseWord:
+			top.state = caseIn
+			return nil
+		case caseIn:
+			if keyword != "in" {
+				return lexer.error(token.offset, "expected \"in\"")
+			}
+
+			top.state = casePattern
+			return nil
+		case casePattern:
+			if keyword == "esac" {
+				lexer.frames = lexer.frames[:len(lexer.frames)-1]
+				lexer.start = false
+			}
+
+			return nil
+		}
+	}
+
+	// coproc NAME is followed by a compound command
+	count := len(lexer.tokens)
+	if keyword == "{" && count >= 3 && lexer.tokens[count-2].kind == wordToken &&
+		lexer.tokens[count-3].kind == wordToken &&
+		!lexer.tokens[count-3].quoted && lexer.tokens[count-3].raw == "coproc" {
+		lexer.start = true
+	}
+
+	if !lexer.start {
+		return nil
+	}
+
+	lexer.start = false
+
+	switch keyword {
+	case "if":
+		lexer.push("fi", token.offset)
+		lexer.start = true
+	case "then", "elif", "else":
+		lexer.start = true
+		return lexer.expect("fi", keyword, token.offset)
+	case "fi", "done", "}":
+		return lexer.pop(keyword, token.offset)
+	case "while", "until":
+		lexer.push("done", token.offset)
+		lexer.start = true
+	case "for", "select":
+		lexer.push("done", token.offset)
+	case "do":
+		lexer.start = true
+		return lexer.expect("done", keyword, token.offset)
+	case "case":
+		lexer.push("esac", token.offset)
+	case "esac":
+		return lexer.pop(keyword, token.offset)
+	case "{":
+		lexer.push("}", token.offset)
+		lexer.start = true
+	case "!", "time", "coproc":
+		lexer.start = true
+	case "-p":
+		// time -p is still followed by a pipeline
+		if count >= 2 && lexer.tokens[count-2].kind == wordToken &&
+			!lexer.tokens[count-2].quoted && lexer.tokens[count-2].raw == "time" {
+			lexer.start = true
+		}
+	case "function":
+		lexer.function = true
+	}
+
+	return nil
+}
+
+func (lexer *lexer) readHeredocs() error {
+	for _, heredoc := range lexer.heredocs {
+		start := lexer.offset
+		for {
+			if lexer.offset >= len(lexer.input) {
+				return lexer.error(
+					heredoc.offset,
+					"here-document delimited by %q is not terminated",
+					heredoc.delimiter,
+				)
+			}
+
+			line := lexer.input[lexer.offset:]
+			end := strings.IndexByte(line, '\n')
+			if end == -1 {
+				lexer.offset = len(lexer.input)
+			} else {
+				line = line[:end]
+				lexer.offset += end + 1
+			}
+
+			if heredoc.strip {
+				line = strings.TrimLeft(line, "\t")
+			}
+
+			if line == heredoc.delimiter {
+				break
+			}
+		}
+
+		if heredoc.expand {
+			if err := lexer.expandHeredoc(start, lexer.offset); err != nil {
+				return err
+			}
+		}
+	}
+
+	lexer.heredocs = nil
+	return nil
+}
+
+// expandHeredoc collects command substitutions from the here-document body
+// which is expanded by the shell when its delimiter is not quoted
+func (lexer *lexer) expandHeredoc(start int, end int) error {
+	body := newLexer(lexer.input, start, false)
+	for body.offset < end {
+		var err error
+
+		switch body.input[body.offset] {
+		case '\\':
+			body.offset += 2
+		case '`':
+			_, err = body.backquote()
+		case '$':
+			_, err = body.dollar(true)
+		default:
+			body.offset++
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	lexer.substitutions = append(lexer.substitutions, body.substitutions...)
+	return nil
+}
+
+func (lexer *lexer) finish() error {
+	end := len(lexer.input)
+
+	if lexer.heredoc != nil || lexer.redirect {
+		return lexer.error(end, "unexpected end of input after redirection")
+	}
+
+	if len(lexer.heredocs) > 0 {
+		return lexer.error(
+			lexer.heredocs[0].offset,
+			"here-document delimited by %q is not terminated",
+			lexer.heredocs[0].delimiter,
+		)
+	}
+
+	if top := lexer.top(); top != nil {
+		return lexer.error(
+			top.offset,
+			"unexpected end of input, expecting %q",
+			top.closer,
+		)
+	}
+
+	for index := len(lexer.tokens) - 1; index >= 0; index-- {
+		token := lexer.tokens[index]
+		if token.kind == operatorToken && token.value == "\n" {
+			continue
+		}
+
+		if token.kind == operatorToken && token.value != ";" &&
+			token.value != "&" && controls[token.value] {
+			return lexer.error(
+				token.offset,
+				"unexpected end of input after %q",
+				token.value,
+			)
+		}
+
+		break
+	}
+
+	return nil
+}
+
+func (lexer *lexer) finishNested(offset int) error {
+	if err := lexer.finish(); err != nil {
+		return err
+	}
+
+	lexer.offset = offset + 1
+	return nil
+}
+
+func (lexer *lexer) word() (token, error) {
+	result := token{kind: wordToken, offset: lexer.offset}
+	value := strings.Builder{}
+	lexer.expanded = false
+
+	for lexer.offset < len(lexer.input) {
+		char := lexer.input[lexer.offset]
+		if lexer.offset == result.offset && lexer.process() {
+			content, err := lexer.substitute(lexer.offset + 2)
+			if err != nil {
+				return result, err
+			}
+
+			value.WriteString(content)
+			continue
+		}
+
+		if strings.IndexByte(" \t\n;&|<>()", char) != -1 {
+			break
+		}
+
+		switch char {
+		case '\\':
+			if lexer.offset+1 >= len(lexer.input) {
+				return result, lexer.error(lexer.offset, "unexpected end of input "+
+					"after backslash")
+			}
+
+			if lexer.input[lexer.offset+1] != '\n' {
+				value.WriteByte(lexer.input[lexer.offset+1])
+				result.quoted = true
+			}
+
+			lexer.offset += 2
+		case '\'':
+			end := strings.IndexByte(lexer.input[lexer.offset+1:], '\'')
+			if end == -1 {
+				return result, lexer.error(lexer.offset, "unterminated single quote")
+			}
+
+			value.WriteString(lexer.input[lexer.offset+1 : lexer.offset+1+end])
+			lexer.offset += end + 2
+			result.quoted = true
+		case '"':
+			content, err := lexer.double()
+			if err != nil {
+				return result, err
+			}
+
+			value.WriteString(content)
+			result.quoted = true
+		case '`':
+			content, err := lexer.backquote()
+			if err != nil {
+				return result, err
+			}
+
+			value.WriteString(content)
+		case '$':
+			content, err := lexer.dollar(false)
+			if err != nil {
+				return result, err
+			}
+
+			value.WriteString(content)
+		default:
+			value.WriteByte(char)
+			lexer.offset++
+		}
+	}
+
+	result.value = value.String()
+	result.raw = lexer.input[result.offset:lexer.offset]
+	result.expanded = lexer.expanded
+	return result, nil
+}
+
+func (lexer *lexer) double() (string, error) {
+	start := lexer.offset
+	value := strings.Builder{}
+	lexer.offset++
+
+	for lexer.offset < len(lexer.input) {
+		char := lexer.input[lexer.offset]
+		switch char {
+		case '"':
+			lexer.offset++
+			return value.String(), nil
+		case '\\':
+			if lexer.offset+1 >= len(lexer.input) {
+				lexer.offset++
+				continue
+			}
+
+			next := lexer.input[lexer.offset+1]
+			if strings.IndexByte("$`\"\\", next) != -1 {
+				value.WriteByte(next)
+			} else if next != '\n' {
+				value.WriteByte(char)
+				value.WriteByte(next)
+			}
+
+			lexer.offset += 2
+		case '`':
+			content, err := lexer.backquote()
+			if err != nil {
+				return "", err
+			}
+
+			value.WriteString(content)
+		case '$':
+			content, err := lexer.dollar(true)
+			if err != nil {
+				return "", err
+			}
+
+			value.WriteString(content)
+		default:
+			value.WriteByte(char)
+			lexer.offset++
+		}
+	}
+
+	return "", lexer.error(start, "unterminated double quote")
+}
+
+func (lexer *lexer) backquote() (string, error) {
+	start := lexer.offset
+	command := strings.Builder{}
+	lexer.offset++
+
+	for lexer.offset < len(lexer.input) {
+		char := lexer.input[lexer.offset]
+		switch char {
+		case '\\':
+			next := byte(0)
+			if lexer.offset+1 < len(lexer.input) {
+				next = lexer.input[lexer.offset+1]
+			}
+
+			if strings.IndexByte("$`\\", next) == -1 {
+				command.WriteByte(char)
+			}
+
+			if next != 0 {
+				command.WriteByte(next)
+			}
+
+			lexer.offset += 2
+		case '`':
+			lexer.offset++
+			lexer.expanded = true
+			lexer.substitutions = append(lexer.substitutions, command.String())
+			return lexer.input[start:lexer.offset], nil
+		default:
+			command.WriteByte(char)
+			lexer.offset++
+		}
+	}
+
+	return "", lexer.error(start, "unterminated backquote")
+}
+
+func (lexer *lexer) dollar(quoted bool) (string, error) {
+	start := lexer.offset
+	rest := lexer.input[lexer.offset:]
+	lexer.expanded = true
+
+	switch {
+	case strings.HasPrefix(rest, "$(("):
+		return lexer.arithmetic()
+	case strings.HasPrefix(rest, "$("):
+		if _, err := lexer.substitute(start + 2); err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(rest, "${"):
+		if err := lexer.parameter(quoted); err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(rest, "$'") && !quoted:
+		lexer.offset += 2
+		for {
+			if lexer.offset >= len(lexer.input) {
+				return "", lexer.error(start, "unterminated single quote")
+			}
+
+			char := lexer.input[lexer.offset]
+			if char == '\\' {
+				lexer.offset += 2
+				continue
+			}
+
+			lexer.offset++
+			if char == '\'' {
+				break
+			}
+		}
+	default:
+		lexer.offset++
+	}
+
+	if lexer.offset > len(lexer.input) {
+		lexer.offset = len(lexer.input)
+	}
+
+	return lexer.input[start:lexer.offset], nil
+}
+
+// process tells whether a process substitution starts at the offset
+func (lexer *lexer) process() bool {
+	rest := lexer.input[lexer.offset:]
+	return strings.HasPrefix(rest, "<(") || strings.HasPrefix(rest, ">(")
+}
+
+// substitute lexes a command substituted from the offset up to the closing
+// parenthesis and collects it
+func (lexer *lexer) substitute(offset int) (string, error) {
+	start := lexer.offset
+	nested := newLexer(lexer.input, offset, true)
+	if err := nested.run(); err != nil {
+		return "", err
+	}
+
+	lexer.offset = nested.offset
+	lexer.expanded = true
+	lexer.substitutions = append(
+		lexer.substitutions,
+		lexer.input[offset:nested.offset-1],
+	)
+
+	return lexer.input[start:lexer.offset], nil
+}
+
+func (lexer *lexer) arithmetic() (string, error) {
+	start := lexer.offset
+	depth := 0
+	lexer.offset += 3
+
+	// expansions inside are lexed as well to collect their substitutions
+	for lexer.offset < len(lexer.input) {
+		var err error
+
+		switch lexer.input[lexer.offset] {
+		case '$':
+			_, err = lexer.dollar(false)
+		case '`':
+			_, err = lexer.backquote()
+		case '(':
+			depth++
+			lexer.offset++
+		case ')':
+			if depth == 0 {
+				if strings.HasPrefix(lexer.input[lexer.offset:], "))") {
+					lexer.offset += 2
+					return lexer.input[start:lexer.offset], nil
+				}
+
+				return "", lexer.error(start, "unterminated arithmetic expansion")
+			}
+
+			depth--
+			lexer.offset++
+		default:
+			lexer.offset++
+		}
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return "", lexer.error(start, "unterminated arithmetic expansion")
+}
+
+func (lexer *lexer) parameter(quoted bool) error {
+	start := lexer.offset
+	depth := 1
+	lexer.offset += 2
+
+	for lexer.offset < len(lexer.input) {
+		var err error
+
+		switch lexer.input[lexer.offset] {
+		case '\\':
+			lexer.offset += 2
+		case '\'':
+			if quoted {
+				lexer.offset++
+				continue
+			}
+
+			end := strings.IndexByte(lexer.input[lexer.offset+1:], '\'')
+			if end == -1 {
+				return lexer.error(lexer.offset, "unterminated single quote")
+			}
+
+			lexer.offset += end + 2
+		case '"':
+			_, err = lexer.double()
+		case '`':
+			_, err = lexer.backquote()
+		case '$':
+			_, err = lexer.dollar(quoted)
+		case '{':
+			depth++
+			lexer.offset++
+		case '}':
+			depth--
+			lexer.offset++
+			if depth == 0 {
+				return nil
+			}
+		default:
+			lexer.offset++
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return lexer.error(start, "unterminated parameter expansion")
+}
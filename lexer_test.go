@@ -0,0 +1,154 @@
+package shell
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLexerAcceptsCompleteCommands(test *testing.T) {
+	commands := []string{
+		"",
+		"echo TEST",
+		`echo "TEST"`,
+		`echo 'it'\''s' "a \" b" \"`,
+		"echo a#b # comment with ' quote",
+		"echo `pwd` $(pwd) $((1 + (2 * 3))) ${HOME:-/} ${A:-${B}}",
+		`echo "$(echo ")")"`,
+		"echo a \\\n  b",
+		"cd /tmp && ls | grep a || echo none; true &",
+		"echo ERROR 1>&2 2>/dev/null >> file",
+		"if true; then echo a; elif false; then :; else echo b; fi",
+		"for i in 1 2 3; do echo $i; done",
+		"while false\ndo\n  echo\ndone",
+		"case $x in\n  a|b) echo ab;;\n  (c) echo c ;;\n  *) ;;\nesac",
+		"case x in x) case y in y) echo ;; esac ;; esac",
+		"f() { echo a; }; f",
+		"function f { echo a; }",
+		"(cd /tmp; pwd)",
+		"{ echo a; echo b; } > file",
+		"cat <<EOF\nline $HOME\nEOF",
+		"cat <<-'EOF' | grep x\n\tline\n\tEOF\necho done",
+		"echo $(case x in x) echo y;; esac)",
+		"echo {a,b} } {",
+		"a=(1 2 3); echo ${a[@]}",
+		"[[ a < b && -n x ]]",
+		"! false",
+		"diff <(sort a) <(sort b)",
+		"tee >(gzip > out.gz) < <(cat file)",
+		"time { ls; }",
+		"time -p { ls; } 2> times",
+		"coproc NAME { cat; }",
+		"coproc cat file",
+		"time ls | wc -l",
+		`read -r x <<< "hi"`,
+		"cat <<<$HOME 0<<< 'a b'; echo done",
+	}
+
+	for _, command := range commands {
+		assert.NoError(test, Validate(command), command)
+	}
+}
+
+func TestLexerRejectsIncompleteCommands(test *testing.T) {
+	commands := []string{
+		`echo "TEST`,
+		"echo 'TEST",
+		"echo `pwd",
+		"echo $(pwd",
+		"echo $((1 + 2)",
+		"echo ${HOME",
+		"echo TEST \\",
+		"echo TEST |",
+		"echo TEST &&\n",
+		"echo TEST ||",
+		"if true; then echo",
+		"for i in 1 2; do echo $i",
+		"while true; do",
+		"case x in x) echo",
+		"{ echo a",
+		"(echo a",
+		"f() {",
+		"cat <<EOF\nline",
+		"cat <<EOF",
+		"echo >",
+		"cat <<<",
+	}
+
+	for _, command := range commands {
+		err := Validate(command)
+		assert.ErrorAs(test, err, new(*SyntaxError), command)
+	}
+}
+
+func TestLexerRejectsUnexpectedTokens(test *testing.T) {
+	commands := []string{
+		"echo )",
+		"fi",
+		"done",
+		"then echo",
+		"}",
+		"echo a;;",
+		"&& echo",
+		"echo a | | echo b",
+		"if true; do echo; done",
+		"case x echo; esac",
+	}
+
+	for _, command := range commands {
+		err := Validate(command)
+		assert.ErrorAs(test, err, new(*SyntaxError), command)
+	}
+}
+
+func TestLexerReportsOffset(test *testing.T) {
+	err := Validate(`echo "TEST`)
+
+	syntaxErr := &SyntaxError{}
+	assert.ErrorAs(test, err, &syntaxErr)
+	assert.Equal(test, 5, syntaxErr.Offset)
+	assert.Equal(test, "unterminated double quote", syntaxErr.Reason)
+}
+
+func TestLexerSplitsWords(test *testing.T) {
+	tokens, err := lex(`FOO=1 git commit -m "a b" 2>&1 | cat`)
+	assert.NoError(test, err)
+
+	values := []string{}
+	for _, token := range tokens {
+		values = append(values, token.value)
+	}
+
+	expected := []string{"FOO=1", "git", "commit", "-m", "a b", "2>&", "1", "|",
+		"cat"}
+
+	assert.Equal(test, expected, values)
+}
+
+func TestLexerCollectsSubstitutions(test *testing.T) {
+	lexer := newLexer("echo $(date) \"`id \\`whoami\\``\" '$(skipped)'", 0, false)
+	assert.NoError(test, lexer.run())
+	assert.Equal(test, []string{"date", "id `whoami`"}, lexer.substitutions)
+	assert.True(test, lexer.tokens[1].expanded)
+	assert.False(test, lexer.tokens[3].expanded)
+}
+
+func TestLexerCollectsProcessSubstitutions(test *testing.T) {
+	lexer := newLexer("diff <(sort a) <(sort b)", 0, false)
+	assert.NoError(test, lexer.run())
+	assert.Equal(test, []string{"sort a", "sort b"}, lexer.substitutions)
+	assert.True(test, lexer.tokens[1].expanded)
+}
+
+func TestLexerReadsHereStringTarget(test *testing.T) {
+	tokens, err := lex(`read -r x <<< "a b"; echo $x`)
+	assert.NoError(test, err)
+
+	values := []string{}
+	for _, token := range tokens {
+		values = append(values, token.value)
+	}
+
+	expected := []string{"read", "-r", "x", "<<<", "a b", ";", "echo", "$x"}
+	assert.Equal(test, expected, values)
+}
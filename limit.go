@@ -0,0 +1,161 @@
+package shell
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+type OutputPolicy int
+
+const (
+	TruncateTail OutputPolicy = iota
+	TruncateHead
+	FailOnLimit
+	KeepHeadAndTail
+)
+
+const (
+	statusPrefix = "__SHELL_EXIT_STATUS_"
+)
+
+var (
+	ErrOutputLimit = errors.New("shell: output limit exceeded")
+
+	statusSuffixRegexp = regexp.MustCompile(`^\w*$`)
+)
+
+type OutputLimit struct {
+	Bytes  int
+	Lines  int
+	Policy OutputPolicy
+}
+
+type Result struct {
+	Status    Status
+	Truncated bool
+}
+
+// limiter applies OutputLimit to lines of a single command; bytes and lines
+// of stdout and stderr are counted together
+type limiter struct {
+	limit     OutputLimit
+	bytes     int
+	lines     int
+	headFull  bool
+	tail      []message
+	tailBytes int
+	truncated bool
+}
+
+func (limiter *limiter) add(message message, deliver func(message)) {
+	if limiter.limit.Bytes == 0 && limiter.limit.Lines == 0 {
+		deliver(message)
+		return
+	}
+
+	size := len(message.message) + 1
+
+	switch limiter.limit.Policy {
+	case TruncateHead:
+		limiter.push(message, limiter.limit.Bytes, limiter.limit.Lines)
+	case KeepHeadAndTail:
+		headBytes, tailBytes := split(limiter.limit.Bytes)
+		headLines, tailLines := split(limiter.limit.Lines)
+		if !limiter.headFull && limiter.fits(size, headBytes, headLines) {
+			limiter.count(size)
+			deliver(message)
+			return
+		}
+
+		limiter.headFull = true
+		limiter.push(message, tailBytes, tailLines)
+	default:
+		// nothing is delivered after the first cut line so no gap is left
+		if !limiter.truncated &&
+			limiter.fits(size, limiter.limit.Bytes, limiter.limit.Lines) {
+			limiter.count(size)
+			deliver(message)
+			return
+		}
+
+		limiter.truncated = true
+	}
+}
+
+// split divides a limit between head and tail; a tail which gets nothing is
+// -1 since 0 means no limit
+func split(limit int) (int, int) {
+	if limit == 0 {
+		return 0, 0
+	}
+
+	tail := limit / 2
+	if tail == 0 {
+		tail = -1
+	}
+
+	return limit - limit/2, tail
+}
+
+func (limiter *limiter) fits(size int, bytes int, lines int) bool {
+	return (bytes == 0 || limiter.bytes+size <= bytes) &&
+		(lines == 0 || limiter.lines+1 <= lines)
+}
+
+func (limiter *limiter) count(size int) {
+	limiter.bytes += size
+	limiter.lines += 1
+}
+
+func (limiter *limiter) push(message message, bytes int, lines int) {
+	limiter.tail = append(limiter.tail, message)
+	limiter.tailBytes += len(message.message) + 1
+
+	for len(limiter.tail) > 0 &&
+		(bytes != 0 && limiter.tailBytes > bytes ||
+			lines != 0 && len(limiter.tail) > lines) {
+		limiter.tailBytes -= len(limiter.tail[0].message) + 1
+		limiter.tail = limiter.tail[1:]
+		limiter.truncated = true
+	}
+}
+
+func (limiter *limiter) flush(deliver func(message)) {
+	for _, message := range limiter.tail {
+		deliver(message)
+	}
+
+	limiter.tail = nil
+}
+
+func (limiter *limiter) err() error {
+	if limiter.truncated && limiter.limit.Policy == FailOnLimit {
+		return ErrOutputLimit
+	}
+
+	return nil
+}
+
+// partialStatus returns the start of an exit status marker which is still
+// arriving at the end of the buffer
+func partialStatus(buffer string) int {
+	index := strings.LastIndex(buffer, statusPrefix)
+	if index != -1 &&
+		statusSuffixRegexp.MatchString(buffer[index+len(statusPrefix):]) {
+		return index
+	}
+
+	start := len(buffer) - len(statusPrefix) + 1
+	if start < 0 {
+		start = 0
+	}
+
+	for ; start < len(buffer); start++ {
+		if strings.HasPrefix(statusPrefix, buffer[start:]) {
+			return start
+		}
+	}
+
+	return -1
+}
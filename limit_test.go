@@ -0,0 +1,213 @@
+package shell
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runTestLimiter(limit OutputLimit, lines ...string) ([]string, bool, error) {
+	limiter := &limiter{limit: limit}
+	result := []string{}
+	deliver := func(message message) {
+		result = append(result, message.message)
+	}
+
+	for _, line := range lines {
+		limiter.add(message{StdOut, line, nil, false}, deliver)
+	}
+
+	limiter.flush(deliver)
+	return result, limiter.truncated, limiter.err()
+}
+
+func TestLimiterPassesLinesWithoutLimit(test *testing.T) {
+	lines, truncated, err := runTestLimiter(OutputLimit{}, "1", "2", "3")
+	assert.Equal(test, []string{"1", "2", "3"}, lines)
+	assert.False(test, truncated)
+	assert.NoError(test, err)
+}
+
+func TestLimiterTruncatesTail(test *testing.T) {
+	limit := OutputLimit{Lines: 2}
+	lines, truncated, err := runTestLimiter(limit, "1", "2", "3", "4")
+	assert.Equal(test, []string{"1", "2"}, lines)
+	assert.True(test, truncated)
+	assert.NoError(test, err)
+}
+
+func TestLimiterTruncatesHead(test *testing.T) {
+	limit := OutputLimit{Lines: 2, Policy: TruncateHead}
+	lines, truncated, _ := runTestLimiter(limit, "1", "2", "3", "4")
+	assert.Equal(test, []string{"3", "4"}, lines)
+	assert.True(test, truncated)
+}
+
+func TestLimiterKeepsHeadAndTail(test *testing.T) {
+	limit := OutputLimit{Lines: 3, Policy: KeepHeadAndTail}
+	lines, truncated, _ := runTestLimiter(limit, "1", "2", "3", "4", "5")
+	assert.Equal(test, []string{"1", "2", "5"}, lines)
+	assert.True(test, truncated)
+}
+
+func TestLimiterKeepsOnlyHeadWithLimitOfOne(test *testing.T) {
+	limit := OutputLimit{Lines: 1, Policy: KeepHeadAndTail}
+	lines, truncated, _ := runTestLimiter(limit, "1", "2", "3")
+	assert.Equal(test, []string{"1"}, lines)
+	assert.True(test, truncated)
+
+	limit = OutputLimit{Bytes: 1, Policy: KeepHeadAndTail}
+	lines, truncated, _ = runTestLimiter(limit, "1", "2")
+	assert.Empty(test, lines)
+	assert.True(test, truncated)
+}
+
+func TestLimiterLimitsBytes(test *testing.T) {
+	limit := OutputLimit{Bytes: len("11\n22\n")}
+	lines, truncated, _ := runTestLimiter(limit, "11", "22", "3")
+	assert.Equal(test, []string{"11", "22"}, lines)
+	assert.True(test, truncated)
+}
+
+func TestLimiterLimitsBytesOfTail(test *testing.T) {
+	limit := OutputLimit{Bytes: len("22\n3\n"), Policy: TruncateHead}
+	lines, _, _ := runTestLimiter(limit, "11", "22", "3")
+	assert.Equal(test, []string{"22", "3"}, lines)
+}
+
+func TestLimiterReturnsErrorOnLimit(test *testing.T) {
+	limit := OutputLimit{Lines: 1, Policy: FailOnLimit}
+	lines, truncated, err := runTestLimiter(limit, "1", "2")
+	assert.Equal(test, []string{"1"}, lines)
+	assert.True(test, truncated)
+	assert.ErrorIs(test, err, ErrOutputLimit)
+}
+
+func TestLimiterLeavesNoGapAfterTruncation(test *testing.T) {
+	limit := OutputLimit{Bytes: 6}
+	lines, truncated, _ := runTestLimiter(limit, "aa", "bbbbb", "cc")
+	assert.Equal(test, []string{"aa"}, lines)
+	assert.True(test, truncated)
+
+	limit = OutputLimit{Bytes: 6, Policy: FailOnLimit}
+	lines, truncated, err := runTestLimiter(limit, "aa", "bbbbb", "cc")
+	assert.Equal(test, []string{"aa"}, lines)
+	assert.True(test, truncated)
+	assert.ErrorIs(test, err, ErrOutputLimit)
+}
+
+func TestLimiterDoesNotTruncateWithinLimit(test *testing.T) {
+	limit := OutputLimit{Lines: 2, Policy: KeepHeadAndTail}
+	lines, truncated, _ := runTestLimiter(limit, "1", "2")
+	assert.Equal(test, []string{"1", "2"}, lines)
+	assert.False(test, truncated)
+}
+
+func TestPartialStatusFindsArrivingMarker(test *testing.T) {
+	assert.Equal(test, 4, partialStatus("TEST__SHELL_EX"))
+	assert.Equal(test, 4, partialStatus("TEST__SHELL_EXIT_STATUS_12"))
+	assert.Equal(test, 4, partialStatus("TEST_"))
+	assert.Equal(test, -1, partialStatus("TEST"))
+	assert.Equal(test, -1, partialStatus("__SHELL_EXIT_STATUS_1 TEST"))
+}
+
+func TestShellDetectsStatusSplitByLineLimit(test *testing.T) {
+	state := newTestShellState(4)
+	defer state.shell.close()
+	status, err := state.run("COMMAND", func() {
+		state.stdout.Write([]byte("MESSAGE__SHELL_EXIT"))
+		state.stdout.Write([]byte("_STATUS_3__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_3__"))
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, 3, status)
+	assert.Equal(test, []string{"OUT: SAGE"}, state.args)
+}
+
+func TestShellReportsLineLimitTruncation(test *testing.T) {
+	state := newTestShellState(len("MESSAGE1"))
+	defer state.shell.close()
+	go func() {
+		state.stdout.Write([]byte("MESSAGE1"))
+		state.stdout.Write([]byte("MESSAGE2"))
+		state.stdout.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+	}()
+
+	go func() {
+		bytes := make([]byte, 1024)
+		for {
+			if _, err := state.stdin.Read(bytes); err != nil {
+				break
+			}
+		}
+	}()
+
+	result, err := state.shell.Exec("COMMAND", state.handler)
+	assert.NoError(test, err)
+	assert.True(test, result.Truncated)
+	assert.Equal(test, []string{"OUT: MESSAGE2"}, state.args)
+}
+
+func TestLocalLimitsOutput(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{
+		OutputLimit: OutputLimit{Lines: 2, Policy: KeepHeadAndTail},
+	})
+
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	lines := []string{}
+	result, err := shell.Exec("seq 1 1000", func(
+		kind MessageType,
+		line string,
+	) error {
+		lines = append(lines, line)
+		return nil
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, Result{Status: 0, Truncated: true}, result)
+	assert.Equal(test, []string{"1", "1000"}, lines)
+
+	result, err = shell.Exec("echo ALIVE", nil)
+	assert.NoError(test, err)
+	assert.Equal(test, Result{Status: 0, Truncated: false}, result)
+}
+
+func TestLocalFailsOnOutputLimit(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{
+		OutputLimit: OutputLimit{Bytes: 10, Policy: FailOnLimit},
+	})
+
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	status, err := shell.Run("seq 1 1000; (exit 4)", nil)
+	assert.ErrorIs(test, err, ErrOutputLimit)
+	assert.Equal(test, 4, status)
+
+	status, err = shell.Run("echo ALIVE", nil)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+}
+
+func TestLocalInterruptsCommandOnOutputLimit(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{
+		OutputLimit:      OutputLimit{Lines: 2, Policy: FailOnLimit},
+		InterruptOnError: true,
+	})
+
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	lines := []string{}
+	started := time.Now()
+	status, err := shell.Run("seq 1 3; sleep 30", Collect(&lines, nil))
+	assert.ErrorIs(test, err, ErrOutputLimit)
+	assert.Equal(test, 130, status)
+	assert.Equal(test, []string{"1", "2"}, lines)
+	assert.Less(test, time.Since(started), 5*time.Second)
+}
@@ -1,15 +1,59 @@
 package shell
 
-import "os/exec"
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultGracePeriod = 5 * time.Second
+)
 
 type LocalConfig struct {
-	LineLimit int
+	LineLimit   int
+	OutputLimit OutputLimit
+	BufferSize  int
+	SpillDir    string
+	Shell       string
+	Args        []string
+
+	Redact     []string
+	RedactFunc func(string) string
+	Logger     *slog.Logger
+
+	ProbeTimeout     time.Duration
+	GracePeriod      time.Duration
+	InterruptOnError bool
+
+	Become       string
+	BecomeMethod BecomeMethod
+	Password     func() (string, error)
 }
 
 func NewLocal(config LocalConfig) (*Local, error) {
-	shell := &Local{command: exec.Command("/bin/sh")}
+	command := interpreter(config.Shell, config.Args)
+	shell := &Local{command: exec.Command(command[0], command[1:]...)}
+	shell.command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	shell.limit = config.LineLimit
-	shell.messages = make(chan message, 4096)
+	shell.output = config.OutputLimit
+	shell.interrupt = config.InterruptOnError
+	shell.redaction = newRedactor(config.Redact, config.RedactFunc)
+	shell.log = config.Logger
+	shell.interpreter = command
+	shell.method = config.BecomeMethod
+	shell.password = config.Password
+	shell.messages = newQueue(config.BufferSize, config.SpillDir)
+
+	shell.grace = config.GracePeriod
+	if shell.grace == 0 {
+		shell.grace = defaultGracePeriod
+	}
 
 	var err error
 
@@ -33,16 +77,129 @@ func NewLocal(config LocalConfig) (*Local, error) {
 		return shell, err
 	}
 
+	shell.exited = make(chan struct{})
+	go func() {
+		shell.command.Wait()
+		shell.status = shell.command.ProcessState.ExitCode()
+		close(shell.exited)
+	}()
+
+	shell.signal = shell.Signal
+	shell.terminate = shell.terminateGroup
 	shell.start()
 
+	if err := shell.probe(config.ProbeTimeout); err != nil {
+		shell.Close()
+		return nil, err
+	}
+
+	shell.pid, err = shell.processID()
+	if err != nil {
+		shell.Close()
+		return nil, err
+	}
+
+	if config.Become != "" {
+		if err := shell.become(config.Become); err != nil {
+			shell.Close()
+			return nil, err
+		}
+	}
+
+	shell.log.Info(
+		"shell: session started",
+		"interpreter", command,
+		"pid", shell.pid,
+		"user", shell.user,
+	)
+
 	return shell, nil
 }
 
 type Local struct {
 	shell
 	command *exec.Cmd
+	grace   time.Duration
+}
+
+func (shell *Local) Signal(signal os.Signal) error {
+	number, ok := signal.(syscall.Signal)
+	if !ok {
+		return errors.New("shell: unsupported signal " + signal.String())
+	}
+
+	pids, err := descendants(shell.pid, shell.backgroundPIDs()...)
+	if err != nil {
+		return err
+	}
+
+	denied := []string{}
+	for _, pid := range pids {
+		err := syscall.Kill(pid, number)
+		if err == syscall.EPERM && shell.user != "" {
+			denied = append(denied, strconv.Itoa(pid))
+		} else if err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+
+	if len(denied) == 0 {
+		return nil
+	}
+
+	argv := append([]string{"kill", "-" + strconv.Itoa(int(number))}, denied...)
+	command, input, err := shell.privileged(shell.user, argv)
+	if err != nil {
+		return err
+	}
+
+	kill := exec.Command(defaultShell, "-c", command)
+	kill.Stdin = strings.NewReader(input)
+	return kill.Run()
 }
 
 func (shell *Local) Close() error {
 	return shell.close()
 }
+
+func (shell *Local) terminateGroup() error {
+	select {
+	case <-shell.exited:
+	case <-time.After(shell.grace):
+	}
+
+	group := shell.command.Process.Pid
+	if shell.await(group, 0) {
+		return nil
+	}
+
+	syscall.Kill(-group, syscall.SIGTERM)
+	if shell.await(group, shell.grace) {
+		return nil
+	}
+
+	syscall.Kill(-group, syscall.SIGKILL)
+	<-shell.exited
+
+	return nil
+}
+
+func (shell *Local) await(group int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		select {
+		case <-shell.exited:
+			if !groupAlive(group) {
+				return true
+			}
+		default:
+		}
+
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
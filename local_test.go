@@ -1,7 +1,15 @@
 package shell
 
 import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -73,3 +81,301 @@ func TestLocalExitsWithoutErrorWhileExecutingCommand(test *testing.T) {
 	err = shell.Close()
 	assert.NoError(test, err)
 }
+
+func TestLocalRunsCommandsInAvailableShells(test *testing.T) {
+	configs := []LocalConfig{
+		{Shell: "/bin/sh"},
+		{Shell: "dash"},
+		{Shell: "bash"},
+		{Shell: "bash", Args: []string{"-l"}},
+		{Shell: "zsh"},
+		{Shell: "busybox", Args: []string{"sh"}},
+	}
+
+	for _, config := range configs {
+		config := config
+		name := strings.Join(append([]string{config.Shell}, config.Args...), " ")
+
+		test.Run(name, func(test *testing.T) {
+			if _, err := exec.LookPath(config.Shell); err != nil {
+				test.Skip(config.Shell + " is not available")
+			}
+
+			shell, err := NewLocal(config)
+			assert.NoError(test, err)
+			defer shell.Close()
+
+			state := testLocalState{shell: shell}
+
+			status, err := state.shell.Run("cd /var/lib", state.handler)
+			assert.NoError(test, err)
+			assert.Equal(test, 0, status)
+
+			status, err = state.shell.Run("echo `pwd`; echo ERROR 1>&2; (exit 3)", state.handler)
+			assert.NoError(test, err)
+			assert.Equal(test, 3, status)
+			assert.Contains(test, state.args, "OUT: /var/lib")
+			assert.Contains(test, state.args, "ERR: ERROR")
+		})
+	}
+}
+
+func TestLocalReturnsErrorOnUnsupportedShell(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{
+		Shell:        "cat",
+		ProbeTimeout: 100 * time.Millisecond,
+	})
+
+	assert.Nil(test, shell)
+	assert.ErrorIs(test, err, ErrUnsupportedShell)
+}
+
+func TestLocalRejectsIncompleteCommand(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	_, err := state.shell.Run(`echo "TEST`, state.handler)
+	assert.ErrorAs(test, err, new(*SyntaxError))
+
+	status, err := state.shell.Run(`echo "TEST"`, state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, "OUT: TEST", state.args[0])
+}
+
+func TestLocalSignalInterruptsRunningCommand(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	go func() {
+		for {
+			pids, _ := descendants(state.shell.command.Process.Pid)
+			if len(pids) > 0 {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		state.shell.Signal(syscall.SIGINT)
+	}()
+
+	status, err := state.shell.Run("sleep 100", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 130, status)
+
+	status, err = state.shell.Run("echo ALIVE", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, "OUT: ALIVE", state.args[0])
+}
+
+func TestLocalCloseKillsRunningCommandAfterGracePeriod(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{GracePeriod: 100 * time.Millisecond})
+	assert.NoError(test, err)
+
+	group := shell.command.Process.Pid
+	go func() { shell.Run("sleep 100", nil) }()
+	time.Sleep(50 * time.Millisecond)
+
+	started := time.Now()
+	err = shell.Close()
+	assert.NoError(test, err)
+	assert.Less(test, time.Since(started), 2*time.Second)
+	assert.False(test, groupAlive(group))
+}
+
+func TestLocalCloseKillsBackgroundProcesses(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{GracePeriod: 100 * time.Millisecond})
+	assert.NoError(test, err)
+
+	group := shell.command.Process.Pid
+	status, err := shell.Run("sleep 100 > /dev/null 2>&1 &", nil)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+
+	err = shell.Close()
+	assert.NoError(test, err)
+	assert.False(test, groupAlive(group))
+}
+
+func TestLocalReportsExitStatus(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{})
+	assert.NoError(test, err)
+	assert.Equal(test, -1, shell.ExitStatus())
+
+	_, err = shell.Run("exit 3", nil)
+	assert.Error(test, err)
+
+	assert.NoError(test, shell.Close())
+	assert.Equal(test, 3, shell.ExitStatus())
+}
+
+func TestLocalClosesUnderLoad(test *testing.T) {
+	for index := 0; index < 20; index++ {
+		shell, err := NewLocal(LocalConfig{GracePeriod: 50 * time.Millisecond})
+		assert.NoError(test, err)
+
+		go func() { shell.Run("while true; do echo LINE; done", nil) }()
+		time.Sleep(time.Duration(index) * time.Millisecond)
+
+		errs := make(chan error, 2)
+		go func() { errs <- shell.Close() }()
+		go func() { errs <- shell.Close() }()
+
+		assert.NoError(test, <-errs)
+		assert.NoError(test, <-errs)
+	}
+}
+
+func TestLocalPingsShell(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(test, state.shell.Ping(ctx))
+	assert.True(test, state.shell.Alive())
+}
+
+func TestLocalPingTimesOutOnBusyShell(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{GracePeriod: 100 * time.Millisecond})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	go func() { shell.Run("sleep 100", nil) }()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(test, shell.Ping(ctx), context.DeadlineExceeded)
+}
+
+func TestLocalDoneIsClosedOnExit(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	state.shell.Run("exit 0", nil)
+
+	select {
+	case <-state.shell.Done():
+	case <-time.After(time.Second):
+		test.Fatal("done channel is not closed")
+	}
+
+	assert.False(test, state.shell.Alive())
+}
+
+func TestLocalRedactsSecretsInOutput(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{Redact: []string{"s3cr3t"}})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	output := []string{}
+	status, err := shell.Run("echo token=s3cr3t; echo s3cr3t 1>&2", func(
+		kind MessageType,
+		line string,
+	) error {
+		output = append(output, line)
+		return nil
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.ElementsMatch(test, []string{"token=***", "***"}, output)
+}
+
+func TestLocalLogsSession(test *testing.T) {
+	output := &bytes.Buffer{}
+	shell, err := NewLocal(LocalConfig{
+		Logger: slog.New(slog.NewTextHandler(output, nil)),
+		Redact: []string{"s3cr3t"},
+	})
+
+	assert.NoError(test, err)
+
+	_, err = shell.Run("echo s3cr3t", nil)
+	assert.NoError(test, err)
+	assert.NoError(test, shell.Close())
+
+	log := output.String()
+	assert.Contains(test, log, `msg="shell: session started"`)
+	assert.Contains(test, log, `command="echo ***"`)
+	assert.Contains(test, log, `msg="shell: session closed" status=0`)
+	assert.NotContains(test, log, "s3cr3t")
+}
+
+func TestLocalLogsRedactedQueries(test *testing.T) {
+	output := &bytes.Buffer{}
+	shell, err := NewLocal(LocalConfig{
+		Logger: slog.New(slog.NewTextHandler(
+			output,
+			&slog.HandlerOptions{Level: slog.LevelDebug},
+		)),
+		Redact:   []string{"s3cr3t"},
+		Password: func() (string, error) { return "hunter2", nil },
+	})
+
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	_, err = shell.Run("echo s3cr3t", nil)
+	assert.NoError(test, err)
+	shell.RunAs("admin", "id", nil)
+
+	log := output.String()
+	assert.Contains(test, log, `msg="shell: write"`)
+	assert.Contains(test, log, `query="echo ***\n`)
+	assert.Contains(test, log, `printf '%s\\n' *** | sudo`)
+	assert.NotContains(test, log, "s3cr3t")
+	assert.NotContains(test, log, "hunter2")
+}
+
+func TestLocalInterruptsCommandOnHandlerError(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{InterruptOnError: true})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	started := time.Now()
+	status, err := shell.Run(
+		"echo READY; tail -f /dev/null",
+		StopOn(regexp.MustCompile(`^READY$`), nil),
+	)
+
+	assert.ErrorIs(test, err, ErrStopped)
+	assert.Equal(test, 130, status)
+	assert.Less(test, time.Since(started), 5*time.Second)
+
+	status, err = shell.Run("echo ALIVE", nil)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+}
+
+func TestLocalInterruptKeepsBackgroundJobs(test *testing.T) {
+	// background commands ignore SIGINT unless they set a handler
+	if _, err := exec.LookPath("perl"); err != nil {
+		test.Skip("perl is required to handle SIGINT in background")
+	}
+
+	shell, err := NewLocal(LocalConfig{InterruptOnError: true})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	id, err := shell.Background("perl -e '$SIG{INT} = sub { exit 7 }; sleep 100'")
+	assert.NoError(test, err)
+
+	status, err := shell.Run(
+		"echo READY; sleep 1; tail -f /dev/null",
+		StopOn(regexp.MustCompile(`^READY$`), nil),
+	)
+
+	assert.ErrorIs(test, err, ErrStopped)
+	assert.Equal(test, 130, status)
+
+	job, err := shell.JobStatus(id)
+	assert.NoError(test, err)
+	assert.True(test, job.Running)
+	assert.NoError(test, shell.KillJob(id))
+}
@@ -0,0 +1,651 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type PolicyViolation struct {
+	Command string
+	Reason  string
+}
+
+func (err *PolicyViolation) Error() string {
+	return fmt.Sprintf(
+		"shell: policy violation: %s in %q",
+		err.Reason,
+		err.Command,
+	)
+}
+
+// Policy is checked against every simple command including the ones in
+// command substitutions; Allow and Deny match executable names or paths,
+// DenyPatterns match the command words joined by a space and DenyWrites
+// match redirection targets. Expanded executables are rejected when Allow or
+// Deny is set and expanded or relative write targets when DenyWrites is set,
+// since the working directory of the session is not known.
+type Policy struct {
+	Allow        []string
+	Deny         []string
+	DenyPatterns []*regexp.Regexp
+	DenyWrites   []string
+
+	Redact     []string
+	RedactFunc func(string) string
+}
+
+// wrapper describes options of a command which runs another command given
+// by its arguments; arguments lists short options taking a value, query lists
+// options after which nothing is run and unsafe lists options which run a
+// command line the policy can not see through
+type wrapper struct {
+	arguments   string
+	long        []string
+	query       string
+	unsafe      string
+	positional  int
+	assignments bool
+}
+
+var (
+	reserved = map[string]bool{
+		"if": true, "then": true, "else": true, "elif": true, "fi": true,
+		"while": true, "until": true, "do": true, "done": true, "esac": true,
+		"{": true, "}": true, "!": true,
+	}
+
+	wrappers = map[string]wrapper{
+		"builtin": {},
+		"command": {query: "vV"},
+		"exec":    {arguments: "a"},
+		"nohup":   {},
+		"setsid":  {},
+		"time":    {arguments: "fo", long: []string{"format", "output"}},
+		"env": {
+			arguments:   "uC",
+			long:        []string{"unset", "chdir"},
+			unsafe:      "S",
+			assignments: true,
+		},
+		"nice":   {arguments: "n", long: []string{"adjustment"}},
+		"ionice": {arguments: "cnp", long: []string{"class", "classdata", "pid"}},
+		"stdbuf": {arguments: "ioe", long: []string{"input", "output", "error"}},
+		"chroot": {long: []string{"userspec", "groups"}, positional: 1},
+		"doas":   {arguments: "uC"},
+		"timeout": {
+			arguments:  "sk",
+			long:       []string{"signal", "kill-after"},
+			positional: 1,
+		},
+		"sudo": {
+			arguments: "ugCDhprtUTR",
+			long: []string{"user", "group", "close-from", "chdir", "host",
+				"prompt", "role", "type", "other-user", "command-timeout",
+				"chroot"},
+			query:       "lvkK",
+			assignments: true,
+		},
+		"xargs": {
+			arguments: "adEILnPs",
+			long: []string{"arg-file", "delimiter", "eof", "replace",
+				"max-lines", "max-args", "max-procs", "max-chars",
+				"process-slot-var"},
+		},
+	}
+
+	shells = map[string]bool{
+		"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true,
+		"ash": true, "mksh": true,
+	}
+
+	writes = map[string]bool{
+		">": true, ">>": true, ">|": true, ">&": true, "&>": true, "&>>": true,
+		"<>": true,
+	}
+
+	assignmentRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+)
+
+type invocation struct {
+	words   []token
+	targets []token
+}
+
+// Check returns a *PolicyViolation when command violates the policy and a
+// *SyntaxError when it can not be parsed
+func (policy Policy) Check(command string) error {
+	reason, err := policy.check(command)
+	if err != nil {
+		return err
+	}
+
+	if reason != "" {
+		return &PolicyViolation{Command: command, Reason: reason}
+	}
+
+	return nil
+}
+
+func (policy Policy) check(command string) (string, error) {
+	lexer := newLexer(command, 0, false)
+	if err := lexer.run(); err != nil {
+		return "", err
+	}
+
+	for _, invocation := range invocations(lexer.tokens) {
+		if reason := policy.invocation(invocation); reason != "" {
+			return reason, nil
+		}
+	}
+
+	for _, substitution := range lexer.substitutions {
+		reason, err := policy.check(substitution)
+		if err != nil || reason != "" {
+			return reason, err
+		}
+	}
+
+	return "", nil
+}
+
+// strict tells whether the policy restricts anything
+func (policy Policy) strict() bool {
+	return len(policy.Allow) > 0 || len(policy.Deny) > 0 ||
+		len(policy.DenyPatterns) > 0 || len(policy.DenyWrites) > 0
+}
+
+func (policy Policy) invocation(invocation invocation) string {
+	for _, target := range invocation.targets {
+		if reason := policy.write(target); reason != "" {
+			return reason
+		}
+	}
+
+	words := invocation.words
+	for len(words) > 0 {
+		word := words[0]
+		switch {
+		case !word.quoted && reserved[word.raw]:
+			words = words[1:]
+			continue
+		case !word.quoted && (word.raw == "for" || word.raw == "select" ||
+			word.raw == "case"):
+			return ""
+		case !word.quoted && word.raw == "function":
+			words = words[1:]
+			if len(words) > 0 {
+				words = words[1:]
+			}
+
+			continue
+		case !word.quoted && word.raw == "coproc":
+			// a name is given only before a compound command
+			words = words[1:]
+			if len(words) > 1 && !words[1].quoted && reserved[words[1].raw] {
+				words = words[1:]
+			}
+
+			continue
+		case !word.quoted && assignmentRegexp.MatchString(word.raw):
+			words = words[1:]
+			continue
+		}
+
+		break
+	}
+
+	return policy.command(words)
+}
+
+// command checks the executable and then the command it runs, if any
+func (policy Policy) command(words []token) string {
+	for len(words) > 0 {
+		if reason := policy.executable(words[0]); reason != "" {
+			return reason
+		}
+
+		if words[0].expanded {
+			return ""
+		}
+
+		name := path.Base(words[0].value)
+		switch {
+		case name == "eval":
+			return policy.script(name, words[1:])
+		case name == "alias":
+			return policy.aliases(words[1:])
+		case name == "su":
+			return policy.script(name, suScript(words[1:]))
+		case shells[name]:
+			return policy.script(name, shellScript(words[1:]))
+		}
+
+		wrapper, ok := wrappers[name]
+		if !ok {
+			break
+		}
+
+		rest, reason := wrapper.skip(name, words[1:])
+		if reason != "" && policy.strict() {
+			return reason
+		}
+
+		words = rest
+	}
+
+	if len(words) == 0 {
+		return ""
+	}
+
+	if reason := policy.arguments(path.Base(words[0].value), words[1:]); reason != "" {
+		return reason
+	}
+
+	if path.Base(words[0].value) == "find" {
+		if reason := policy.find(words[1:]); reason != "" {
+			return reason
+		}
+	}
+
+	values := []string{}
+	for _, word := range words {
+		values = append(values, word.value)
+	}
+
+	text := strings.Join(values, " ")
+	for _, pattern := range policy.DenyPatterns {
+		if pattern.MatchString(text) {
+			return fmt.Sprintf("command matches denied pattern %q", pattern)
+		}
+	}
+
+	return ""
+}
+
+// script checks a command line given as arguments of eval, su or a shell
+func (policy Policy) script(name string, words []token) string {
+	if len(words) == 0 || !policy.strict() {
+		return ""
+	}
+
+	values := []string{}
+	for _, word := range words {
+		if word.expanded {
+			return fmt.Sprintf("script of %q is expanded", name)
+		}
+
+		values = append(values, word.value)
+	}
+
+	reason, err := policy.check(strings.Join(values, " "))
+	if err != nil {
+		return fmt.Sprintf("script of %q can not be parsed", name)
+	}
+
+	return reason
+}
+
+// aliases checks the commands aliases are defined to, since the session
+// persists and an alias may be used by a later command
+func (policy Policy) aliases(words []token) string {
+	for _, word := range words {
+		if _, value, ok := strings.Cut(word.value, "="); ok {
+			word.value = value
+			if reason := policy.script("alias", []token{word}); reason != "" {
+				return reason
+			}
+		}
+	}
+
+	return ""
+}
+
+// find checks the commands run by the actions of find
+func (policy Policy) find(words []token) string {
+	for index := 0; index < len(words); index++ {
+		switch words[index].value {
+		case "-exec", "-execdir", "-ok", "-okdir":
+		default:
+			continue
+		}
+
+		end := index + 1
+		for end < len(words) && words[end].value != ";" && words[end].value != "+" {
+			end++
+		}
+
+		if reason := policy.command(words[index+1 : end]); reason != "" {
+			return reason
+		}
+
+		index = end
+	}
+
+	return ""
+}
+
+// arguments checks files written by commands which take them as arguments
+func (policy Policy) arguments(name string, words []token) string {
+	if len(policy.DenyWrites) == 0 {
+		return ""
+	}
+
+	files := []token{}
+	switch name {
+	case "tee":
+		files = operands(words)
+	case "dd":
+		for _, word := range words {
+			if strings.HasPrefix(word.value, "of=") {
+				word.value = strings.TrimPrefix(word.value, "of=")
+				files = append(files, word)
+			}
+		}
+	case "cp", "mv", "install", "ln":
+		for index, word := range words {
+			switch {
+			case word.value == "-t" && index+1 < len(words):
+				files = append(files, words[index+1])
+			case strings.HasPrefix(word.value, "--target-directory="):
+				word.value = strings.TrimPrefix(word.value, "--target-directory=")
+				files = append(files, word)
+			}
+		}
+
+		if rest := operands(words); len(rest) >= 2 {
+			files = append(files, rest[len(rest)-1])
+		}
+	}
+
+	for _, file := range files {
+		if reason := policy.write(file); reason != "" {
+			return reason
+		}
+	}
+
+	return ""
+}
+
+func (policy Policy) executable(word token) string {
+	if word.expanded {
+		if len(policy.Allow) > 0 || len(policy.Deny) > 0 {
+			return fmt.Sprintf("executable %q is expanded", word.raw)
+		}
+
+		return ""
+	}
+
+	if matchExecutable(policy.Deny, word.value) {
+		return fmt.Sprintf("executable %q is denied", word.value)
+	}
+
+	if len(policy.Allow) > 0 && !matchExecutable(policy.Allow, word.value) {
+		return fmt.Sprintf("executable %q is not allowed", word.value)
+	}
+
+	return ""
+}
+
+func (policy Policy) write(target token) string {
+	if len(policy.DenyWrites) == 0 {
+		return ""
+	}
+
+	if target.expanded {
+		return fmt.Sprintf("write target %q is expanded", target.raw)
+	}
+
+	if !strings.HasPrefix(target.value, "/") {
+		return fmt.Sprintf("write target %q is relative", target.value)
+	}
+
+	cleaned := path.Clean(target.value)
+	for _, denied := range policy.DenyWrites {
+		denied = path.Clean(denied)
+		if cleaned == denied ||
+			strings.HasPrefix(cleaned, strings.TrimSuffix(denied, "/")+"/") {
+			return fmt.Sprintf("write to %q is denied", target.value)
+		}
+	}
+
+	return ""
+}
+
+// skip returns the words of the wrapped command; the reason is set when the
+// options can not be followed
+func (wrapper wrapper) skip(name string, words []token) ([]token, string) {
+	options := true
+	positional := wrapper.positional
+	for len(words) > 0 {
+		word := words[0]
+		value := word.value
+
+		switch {
+		case word.expanded && (options || positional > 0):
+			return nil, fmt.Sprintf("arguments of %q are expanded", name)
+		case options && value == "--":
+			options = false
+		case options && strings.HasPrefix(value, "--"):
+			option, _, attached := strings.Cut(value[2:], "=")
+			if !attached && slices.Contains(wrapper.long, option) {
+				words = words[1:]
+			}
+		case options && strings.HasPrefix(value, "-") && len(value) > 1:
+			for index, char := range value[1:] {
+				if strings.ContainsRune(wrapper.unsafe, char) {
+					return nil, fmt.Sprintf("option -%c of %q is not supported", char, name)
+				}
+
+				if strings.ContainsRune(wrapper.query, char) {
+					return nil, ""
+				}
+
+				if strings.ContainsRune(wrapper.arguments, char) {
+					if index == len(value)-2 {
+						words = words[1:]
+					}
+
+					break
+				}
+			}
+		case options && wrapper.assignments &&
+			assignmentRegexp.MatchString(word.raw):
+		case positional > 0:
+			options = false
+			positional--
+		default:
+			return words, ""
+		}
+
+		if len(words) > 0 {
+			words = words[1:]
+		}
+	}
+
+	return nil, ""
+}
+
+// operands returns arguments which are not options
+func operands(words []token) []token {
+	result := []token{}
+	options := true
+	for _, word := range words {
+		switch {
+		case options && word.value == "--":
+			options = false
+		case options && strings.HasPrefix(word.value, "-") && len(word.value) > 1:
+		default:
+			result = append(result, word)
+		}
+	}
+
+	return result
+}
+
+// shellScript returns the command line passed to a shell with -c
+func shellScript(words []token) []token {
+	command := false
+	for len(words) > 0 {
+		value := words[0].value
+		if value == "--" || value == "-" {
+			words = words[1:]
+			break
+		}
+
+		if len(value) < 2 || value[0] != '-' && value[0] != '+' {
+			break
+		}
+
+		words = words[1:]
+		switch {
+		case value == "--rcfile" || value == "--init-file":
+			words = words[min(1, len(words)):]
+		case strings.HasPrefix(value, "--"):
+		case strings.ContainsRune(value[1:], 'o'):
+			words = words[min(1, len(words)):]
+		}
+
+		if value[0] == '-' && !strings.HasPrefix(value, "--") &&
+			strings.ContainsRune(value[1:], 'c') {
+			command = true
+		}
+	}
+
+	if !command || len(words) == 0 {
+		return nil
+	}
+
+	return words[:1]
+}
+
+// suScript returns the command line passed to su with -c
+func suScript(words []token) []token {
+	for index, word := range words {
+		switch {
+		case (word.value == "-c" || word.value == "--command") &&
+			index+1 < len(words):
+			return words[index+1 : index+2]
+		case strings.HasPrefix(word.value, "--command="):
+			word.value = strings.TrimPrefix(word.value, "--command=")
+			return []token{word}
+		}
+	}
+
+	return nil
+}
+
+func matchExecutable(names []string, executable string) bool {
+	for _, name := range names {
+		if name == executable || name == path.Base(executable) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// invocations splits tokens into simple commands dropping function names and
+// case patterns and collecting the targets of redirections which write
+func invocations(tokens []token) []invocation {
+	result := []invocation{}
+	current := invocation{}
+	pattern := false
+
+	keyword := func(index int, word string) bool {
+		return len(current.words) > index && !current.words[index].quoted &&
+			current.words[index].raw == word
+	}
+
+	flush := func() {
+		if keyword(0, "case") {
+			pattern = true
+		}
+
+		if len(current.words) > 0 || len(current.targets) > 0 {
+			result = append(result, current)
+		}
+
+		current = invocation{}
+	}
+
+	for index := 0; index < len(tokens); index++ {
+		token := tokens[index]
+		if token.kind == wordToken {
+			current.words = append(current.words, token)
+			if pattern && keyword(0, "esac") {
+				pattern = false
+			}
+
+			continue
+		}
+
+		operator := strings.TrimLeft(token.value, "0123456789")
+		switch {
+		case redirections[operator]:
+			if index+1 >= len(tokens) {
+				continue
+			}
+
+			index++
+			target := tokens[index]
+			if writes[operator] && (operator != ">&" ||
+				strings.Trim(target.value, "0123456789-") != "") {
+				current.targets = append(current.targets, target)
+			}
+		case operator == "(" && len(current.words) == 1 &&
+			index+1 < len(tokens) && tokens[index+1].value == ")":
+			current.words = nil
+			index++
+		case operator == ")" && (pattern || keyword(0, "case")):
+			current.words = nil
+			pattern = false
+		case operator == ";;" || operator == ";&" || operator == ";;&":
+			flush()
+			pattern = true
+		default:
+			flush()
+		}
+	}
+
+	flush()
+	return result
+}
+
+// Restricted runs only the commands which satisfy the policy; a violating
+// command is rejected before it is written to the shell
+type Restricted struct {
+	shell     Shell
+	policy    Policy
+	redaction redactor
+}
+
+func NewRestricted(shell Shell, policy Policy) *Restricted {
+	return &Restricted{
+		shell:     shell,
+		policy:    policy,
+		redaction: newRedactor(policy.Redact, policy.RedactFunc),
+	}
+}
+
+func (shell *Restricted) Run(
+	command string,
+	handler func(MessageType, string) error,
+) (int, error) {
+	if err := shell.policy.Check(command); err != nil {
+		return -1, shell.redaction.error(err)
+	}
+
+	return shell.shell.Run(command, handler)
+}
+
+func (shell *Restricted) Signal(signal os.Signal) error {
+	return shell.shell.Signal(signal)
+}
+
+func (shell *Restricted) Close() error {
+	return shell.shell.Close()
+}
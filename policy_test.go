@@ -0,0 +1,159 @@
+package shell
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyRejectsDeniedExecutables(test *testing.T) {
+	policy := Policy{Deny: []string{"shutdown", "rm"}}
+
+	commands := []string{
+		"shutdown -h now",
+		"/sbin/shutdown -r now",
+		"echo 1 && rm file",
+		"true | 'rm' file",
+		"FOO=1 exec rm file",
+		"if true; then rm file; fi",
+		"echo $(rm file)",
+		"echo \"`rm file`\"",
+		"f() { rm file; }",
+		"cat <<EOF\n$(rm file)\nEOF",
+	}
+
+	for _, command := range commands {
+		var violation *PolicyViolation
+		assert.ErrorAs(test, policy.Check(command), &violation, command)
+	}
+
+	assert.NoError(test, policy.Check("echo rm; ls -la"))
+	assert.NoError(test, policy.Check("cat <<'EOF'\n$(rm file)\nEOF"))
+	assert.NoError(test, policy.Check("case $1 in rm) echo rm;; esac"))
+}
+
+func TestPolicyAllowsOnlyListedExecutables(test *testing.T) {
+	policy := Policy{Allow: []string{"echo", "cat"}}
+
+	assert.NoError(test, policy.Check("echo 1 | cat > /tmp/out"))
+	assert.EqualError(
+		test,
+		policy.Check("echo 1; ls"),
+		`shell: policy violation: executable "ls" is not allowed in "echo 1; ls"`,
+	)
+
+	assert.Error(test, policy.Check("$COMMAND file"))
+	assert.Error(test, policy.Check("echo $(( $(shutdown) + 1 ))"))
+	assert.Error(test, policy.Check("echo $(( `shutdown` ))"))
+	assert.NoError(test, policy.Check("echo $(( $(echo 1) + (2 * $((3))) ))"))
+	assert.Error(test, policy.Check("case $1 in a) (ls);; esac\n(ls)"))
+}
+
+func TestPolicyFollowsWrappers(test *testing.T) {
+	policy := Policy{Deny: []string{"shutdown"}}
+
+	commands := []string{
+		"env -u X shutdown",
+		"env A=1 shutdown",
+		"env --unset X -- shutdown",
+		"exec -a name shutdown",
+		"nice -n 5 shutdown",
+		"nice -5 shutdown",
+		"timeout 5 shutdown",
+		"timeout -s KILL 5s shutdown",
+		"sudo -u root shutdown",
+		"sudo --user=root HOME=/root shutdown",
+		"echo now | xargs shutdown",
+		"xargs -n 1 -I{} shutdown {}",
+		"eval shutdown",
+		"eval 'echo 1; shutdown'",
+		"eval \"$COMMAND\"",
+		"sh -c shutdown",
+		"bash -ec 'shutdown -h now'",
+		"sh -o errexit -c shutdown",
+		"su -c shutdown root",
+		"alias x=shutdown",
+		"alias ll='ls -l' x='echo; shutdown -h now'",
+		"coproc shutdown",
+		"coproc NAME { shutdown; }",
+		"find / -exec shutdown \\;",
+		"find / -name x -execdir /sbin/shutdown {} +",
+		"find / -ok shutdown ;",
+		"env -S shutdown",
+		"$COMMAND",
+	}
+
+	for _, command := range commands {
+		var violation *PolicyViolation
+		assert.ErrorAs(test, policy.Check(command), &violation, command)
+	}
+
+	assert.NoError(test, policy.Check("command -v shutdown"))
+	assert.NoError(test, policy.Check("sudo -l shutdown"))
+	assert.NoError(test, policy.Check("timeout 5 sleep 1"))
+	assert.NoError(test, policy.Check("sh script.sh shutdown"))
+	assert.NoError(test, policy.Check("eval 'echo shutdown'"))
+	assert.NoError(test, policy.Check("alias ll='ls -l'"))
+	assert.NoError(test, policy.Check("find / -name shutdown -exec ls {} \\;"))
+}
+
+func TestPolicyRejectsDeniedPatterns(test *testing.T) {
+	policy := Policy{
+		DenyPatterns: []*regexp.Regexp{regexp.MustCompile(`^rm -[a-z]*r[a-z]* /$`)},
+	}
+
+	assert.Error(test, policy.Check("rm -rf /"))
+	assert.Error(test, policy.Check("sudo true; rm  -fr '/'"))
+	assert.NoError(test, policy.Check("rm -rf /tmp/build"))
+}
+
+func TestPolicyRejectsDeniedWrites(test *testing.T) {
+	policy := Policy{DenyWrites: []string{"/etc"}}
+
+	assert.Error(test, policy.Check("echo 1 > /etc/hosts"))
+	assert.Error(test, policy.Check("echo 1 2>>/etc/../etc/hosts"))
+	assert.Error(test, policy.Check("echo 1 &> /etc/hosts"))
+	assert.Error(test, policy.Check("echo 1 > $FILE"))
+	assert.Error(test, policy.Check("echo 1 | tee -a /tmp/log /etc/hosts"))
+	assert.Error(test, policy.Check("echo 1 | sudo tee /etc/hosts"))
+	assert.Error(test, policy.Check("dd if=/dev/zero of=/etc/hosts"))
+	assert.Error(test, policy.Check("cp hosts /etc/"))
+	assert.Error(test, policy.Check("mv -t /etc hosts"))
+	assert.Error(test, policy.Check("sh -c 'echo 1 > /etc/hosts'"))
+	assert.Error(test, policy.Check("cd /etc; echo x > passwd"))
+	assert.Error(test, policy.Check("echo x > ../../../../etc/passwd"))
+	assert.Error(test, policy.Check("echo x > passwd"))
+	assert.NoError(test, policy.Check("cp /etc/hosts /tmp/hosts"))
+	assert.NoError(test, policy.Check("dd if=/etc/hosts of=/tmp/hosts"))
+	assert.NoError(test, policy.Check("cat /etc/hosts > /etcetera 2>&1"))
+	assert.NoError(test, policy.Check("cat < /etc/hosts"))
+}
+
+func TestRestrictedRejectsBeforeWriting(test *testing.T) {
+	inner := NewDryRun(DryRunConfig{})
+	shell := NewRestricted(inner, Policy{
+		Deny:   []string{"rm"},
+		Redact: []string{"s3cr3t"},
+	})
+
+	status, err := shell.Run("rm s3cr3t", nil)
+	assert.Equal(test, -1, status)
+
+	var violation *PolicyViolation
+	assert.ErrorAs(test, err, &violation)
+	assert.Equal(test, "rm ***", violation.Command)
+
+	assert.Empty(test, inner.Commands())
+}
+
+func TestRestrictedRunsAllowedCommands(test *testing.T) {
+	state := newTestLocalState()
+	shell := NewRestricted(state.shell, Policy{Allow: []string{"echo"}})
+	defer shell.Close()
+
+	status, err := shell.Run("echo ALLOWED", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"OUT: ALLOWED"}, state.args)
+}
@@ -0,0 +1,120 @@
+package shell
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var errNoProcesses = errors.New("shell: listing processes requires /proc")
+
+// procRoot is where processes are listed from; it is missing on systems like
+// macOS and BSD, where listing fails rather than finding no processes
+var procRoot = "/proc"
+
+type process struct {
+	pid   int
+	ppid  int
+	pgid  int
+	state byte
+}
+
+func processes() ([]process, error) {
+	if _, err := os.Stat(filepath.Join(procRoot, "self", "stat")); err != nil {
+		return nil, errNoProcesses
+	}
+
+	paths, err := filepath.Glob(filepath.Join(procRoot, "[0-9]*", "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []process{}
+	for _, path := range paths {
+		// processes may exit while being listed
+		stat, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		if process, ok := parseProcessStat(string(stat)); ok {
+			result = append(result, process)
+		}
+	}
+
+	return result, nil
+}
+
+// stat format is "pid (comm) state ppid pgrp ...", comm may contain spaces
+// and parentheses
+func parseProcessStat(stat string) (process, bool) {
+	open := strings.IndexByte(stat, '(')
+	close := strings.LastIndexByte(stat, ')')
+	if open == -1 || close == -1 || close < open {
+		return process{}, false
+	}
+
+	fields := strings.Fields(stat[close+1:])
+	if len(fields) < 3 || len(fields[0]) != 1 {
+		return process{}, false
+	}
+
+	pid, pidErr := strconv.Atoi(strings.TrimSpace(stat[:open]))
+	ppid, ppidErr := strconv.Atoi(fields[1])
+	pgid, pgidErr := strconv.Atoi(fields[2])
+	if pidErr != nil || ppidErr != nil || pgidErr != nil {
+		return process{}, false
+	}
+
+	return process{pid: pid, ppid: ppid, pgid: pgid, state: fields[0][0]}, true
+}
+
+// descendants returns the process tree below pid leaving out the excluded
+// processes along with their own trees
+func descendants(pid int, excluded ...int) ([]int, error) {
+	list, err := processes()
+	if err != nil {
+		return nil, err
+	}
+
+	children := map[int][]int{}
+	for _, process := range list {
+		if process.state != 'Z' && !slices.Contains(excluded, process.pid) {
+			children[process.ppid] = append(children[process.ppid], process.pid)
+		}
+	}
+
+	result := []int{}
+	queue := children[pid]
+	for len(queue) > 0 {
+		result = append(result, queue[0])
+		queue = append(queue[1:], children[queue[0]]...)
+	}
+
+	return result, nil
+}
+
+// groupAlive reports whether the group has a process which is not a zombie;
+// without a process list any process of the group counts
+func groupAlive(pgid int) bool {
+	if pgid <= 0 {
+		return false
+	}
+
+	list, err := processes()
+	if err != nil {
+		return syscall.Kill(-pgid, 0) != syscall.ESRCH
+	}
+
+	for _, process := range list {
+		if process.pgid == pgid && process.state != 'Z' {
+			return true
+		}
+	}
+
+	return false
+}
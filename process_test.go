@@ -0,0 +1,62 @@
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessParsesStat(test *testing.T) {
+	process, ok := parseProcessStat("42 (my (odd) cmd) S 1 40 40 0 -1")
+	assert.True(test, ok)
+	assert.Equal(test, 42, process.pid)
+	assert.Equal(test, 1, process.ppid)
+	assert.Equal(test, 40, process.pgid)
+	assert.Equal(test, byte('S'), process.state)
+}
+
+func TestProcessRejectsInvalidStat(test *testing.T) {
+	_, ok := parseProcessStat("garbage")
+	assert.False(test, ok)
+}
+
+func TestProcessListsDescendants(test *testing.T) {
+	command := exec.Command("/bin/sh", "-c", "sleep 10 & sleep 10; wait")
+	assert.NoError(test, command.Start())
+	defer command.Wait()
+	defer command.Process.Kill()
+
+	var children []int
+	for index := 0; index < 100 && len(children) < 2; index++ {
+		children, _ = descendants(command.Process.Pid)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Len(test, children, 2)
+
+	for _, child := range children {
+		process, _ := os.FindProcess(child)
+		process.Kill()
+	}
+}
+
+func TestProcessGroupAlive(test *testing.T) {
+	assert.True(test, groupAlive(syscall.Getpgrp()))
+	assert.False(test, groupAlive(-1))
+}
+
+func TestProcessFailsWithoutProcessList(test *testing.T) {
+	root := procRoot
+	procRoot = test.TempDir()
+	defer func() { procRoot = root }()
+
+	_, err := descendants(os.Getpid())
+	assert.ErrorIs(test, err, errNoProcesses)
+
+	assert.True(test, groupAlive(syscall.Getpgrp()))
+	assert.False(test, groupAlive(-1))
+}
@@ -0,0 +1,197 @@
+package shell
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	defaultBufferSize = 1 << 20
+)
+
+type Backlog struct {
+	Messages     int
+	Bytes        int
+	SpilledBytes int
+}
+
+type queued struct {
+	message message
+	spilled bool
+	offset  int64
+	length  int
+}
+
+// queue delivers messages from readers to the running command; it holds up
+// to size bytes of output in memory and either spills the rest to a file in
+// spill directory or makes readers wait for the handler. Exit status and
+// errors are always admitted so a command can complete whatever the backlog.
+type queue struct {
+	mutex   sync.Mutex
+	items   []queued
+	bytes   int
+	size    int
+	closed  bool
+	changed chan struct{}
+
+	spill   string
+	file    *os.File
+	written int64
+	spilled int
+}
+
+func newQueue(size int, spill string) *queue {
+	if size == 0 {
+		size = defaultBufferSize
+	}
+
+	return &queue{size: size, spill: spill, changed: make(chan struct{})}
+}
+
+// wait returns a channel which is closed on the next change of the queue
+func (queue *queue) wait() <-chan struct{} {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return queue.changed
+}
+
+func (queue *queue) notify() {
+	close(queue.changed)
+	queue.changed = make(chan struct{})
+}
+
+// push returns false when the message does not fit and reader should wait
+// for the handler to catch up
+func (queue *queue) push(message message) (bool, error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.closed {
+		return true, nil
+	}
+
+	item := queued{message: message}
+	size := len(message.message)
+	isLine := message.kind == StdOut || message.kind == StdErr
+	fits := queue.bytes+size <= queue.size || queue.bytes == 0
+
+	if isLine && (queue.spilled > 0 || !fits) {
+		if queue.spill == "" {
+			return false, nil
+		}
+
+		if err := queue.write(&item); err != nil {
+			return false, err
+		}
+	} else {
+		queue.bytes += size
+	}
+
+	queue.items = append(queue.items, item)
+	queue.notify()
+	return true, nil
+}
+
+func (queue *queue) write(item *queued) error {
+	if queue.file == nil {
+		file, err := os.CreateTemp(queue.spill, "shell-spill-*")
+		if err != nil {
+			return err
+		}
+
+		queue.file = file
+	}
+
+	data := []byte(item.message.message)
+	if _, err := queue.file.WriteAt(data, queue.written); err != nil {
+		return err
+	}
+
+	item.spilled = true
+	item.offset = queue.written
+	item.length = len(data)
+	item.message.message = ""
+
+	queue.written += int64(len(data))
+	queue.spilled += len(data)
+	return nil
+}
+
+// pop returns false when there is nothing to deliver yet; closed queue is
+// reported once it is drained
+func (queue *queue) pop() (message, bool, error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if len(queue.items) == 0 {
+		return message{}, false, nil
+	}
+
+	item := queue.items[0]
+	queue.items = queue.items[1:]
+
+	if item.spilled {
+		data := make([]byte, item.length)
+		_, err := queue.file.ReadAt(data, item.offset)
+		if err != nil && err != io.EOF {
+			return message{}, false, err
+		}
+
+		item.message.message = string(data)
+		queue.spilled -= item.length
+
+		// file is reused from the start once everything is read back
+		if queue.spilled == 0 {
+			queue.written = 0
+			queue.file.Truncate(0)
+		}
+	} else {
+		queue.bytes -= len(item.message.message)
+	}
+
+	queue.notify()
+	return item.message, true, nil
+}
+
+func (queue *queue) drained() bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return queue.closed && len(queue.items) == 0
+}
+
+func (queue *queue) backlog() Backlog {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return Backlog{
+		Messages:     len(queue.items),
+		Bytes:        queue.bytes + queue.spilled,
+		SpilledBytes: queue.spilled,
+	}
+}
+
+func (queue *queue) close() error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.closed = true
+	queue.items = nil
+	queue.bytes = 0
+	queue.spilled = 0
+	queue.notify()
+
+	if queue.file == nil {
+		return nil
+	}
+
+	closeErr := queue.file.Close()
+	removeErr := os.Remove(queue.file.Name())
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return removeErr
+}
@@ -0,0 +1,144 @@
+package shell
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func popTestQueue(queue *queue) []string {
+	result := []string{}
+	for {
+		message, ok, err := queue.pop()
+		if err != nil {
+			panic(err)
+		}
+
+		if !ok {
+			return result
+		}
+
+		result = append(result, message.message)
+	}
+}
+
+func TestQueueDeliversMessagesInOrder(test *testing.T) {
+	queue := newQueue(0, "")
+	queue.push(message{StdOut, "1", nil, false})
+	queue.push(message{StdErr, "2", nil, false})
+	assert.Equal(test, []string{"1", "2"}, popTestQueue(queue))
+}
+
+func TestQueueRejectsLinesOverSize(test *testing.T) {
+	queue := newQueue(4, "")
+
+	ok, err := queue.push(message{StdOut, "1234", nil, false})
+	assert.NoError(test, err)
+	assert.True(test, ok)
+
+	ok, err = queue.push(message{StdOut, "5", nil, false})
+	assert.NoError(test, err)
+	assert.False(test, ok)
+}
+
+func TestQueueAdmitsLineLargerThanSizeWhenEmpty(test *testing.T) {
+	queue := newQueue(4, "")
+	ok, _ := queue.push(message{StdOut, "123456", nil, false})
+	assert.True(test, ok)
+}
+
+func TestQueueAlwaysAdmitsExitStatus(test *testing.T) {
+	queue := newQueue(1, "")
+	queue.push(message{StdOut, "1", nil, false})
+
+	ok, _ := queue.push(message{stdoutComplete, "0", nil, false})
+	assert.True(test, ok)
+	assert.Equal(test, []string{"1", "0"}, popTestQueue(queue))
+}
+
+func TestQueueSpillsToDisk(test *testing.T) {
+	directory := test.TempDir()
+	queue := newQueue(2, directory)
+
+	for index := 0; index < 5; index++ {
+		ok, err := queue.push(message{StdOut, strconv.Itoa(index), nil, false})
+		assert.NoError(test, err)
+		assert.True(test, ok)
+	}
+
+	queue.push(message{stdoutComplete, "0", nil, false})
+
+	assert.Equal(test, Backlog{Messages: 6, Bytes: 6, SpilledBytes: 3}, queue.backlog())
+	assert.Equal(test, []string{"0", "1", "2", "3", "4", "0"}, popTestQueue(queue))
+	assert.Equal(test, Backlog{}, queue.backlog())
+
+	entries, _ := os.ReadDir(directory)
+	assert.Len(test, entries, 1)
+
+	assert.NoError(test, queue.close())
+	entries, _ = os.ReadDir(directory)
+	assert.Empty(test, entries)
+}
+
+func TestQueueReportsDrainedAfterClose(test *testing.T) {
+	queue := newQueue(0, "")
+	queue.push(message{StdOut, "1", nil, false})
+	assert.False(test, queue.drained())
+
+	queue.close()
+	assert.True(test, queue.drained())
+}
+
+func TestLocalDeliversOutputToSlowHandler(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{
+		BufferSize: 64,
+		SpillDir:   test.TempDir(),
+	})
+
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	lines := []string{}
+	backlog := 0
+	status, err := shell.Run("seq 1 2000", func(
+		kind MessageType,
+		line string,
+	) error {
+		if len(lines) == 0 {
+			time.Sleep(100 * time.Millisecond)
+			backlog = shell.Backlog().SpilledBytes
+		}
+
+		lines = append(lines, line)
+		return nil
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Len(test, lines, 2000)
+	assert.Equal(test, "2000", lines[1999])
+	assert.Greater(test, backlog, 0)
+}
+
+func TestLocalWaitsForSlowHandlerWithoutSpill(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{BufferSize: 64})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	count := 0
+	status, err := shell.Run("seq 1 2000", func(
+		kind MessageType,
+		line string,
+	) error {
+		count += 1
+		return nil
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, 2000, count)
+	assert.LessOrEqual(test, shell.Backlog().Bytes, 64)
+}
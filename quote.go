@@ -0,0 +1,154 @@
+package shell
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	safeArgument = regexp.MustCompile(`^[\w@%+:,./-]+$`)
+	variableName = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+	reservedWords = map[string]bool{
+		"case": true, "do": true, "done": true, "elif": true, "else": true,
+		"esac": true, "fi": true, "for": true, "function": true, "if": true,
+		"in": true, "select": true, "then": true, "until": true, "while": true,
+	}
+)
+
+func Quote(args ...string) string {
+	result := make([]string, len(args))
+	for index, arg := range args {
+		result[index] = quote(arg)
+	}
+
+	return strings.Join(result, " ")
+}
+
+func quote(arg string) string {
+	if safeArgument.MatchString(arg) && !reservedWords[arg] {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+type CommandBuilder struct {
+	env       [][2]string
+	args      []string
+	redirects []string
+	chain     []commandLink
+}
+
+type commandLink struct {
+	operator string
+	command  *CommandBuilder
+}
+
+func Command(name string, args ...string) *CommandBuilder {
+	return &CommandBuilder{args: append([]string{name}, args...)}
+}
+
+func (command *CommandBuilder) Arg(args ...string) *CommandBuilder {
+	command.args = append(command.args, args...)
+	return command
+}
+
+func (command *CommandBuilder) Env(name string, value string) *CommandBuilder {
+	command.env = append(command.env, [2]string{name, value})
+	return command
+}
+
+func (command *CommandBuilder) Stdin(path string) *CommandBuilder {
+	return command.redirect("<", path)
+}
+
+func (command *CommandBuilder) Stdout(path string) *CommandBuilder {
+	return command.redirect(">", path)
+}
+
+func (command *CommandBuilder) Append(path string) *CommandBuilder {
+	return command.redirect(">>", path)
+}
+
+func (command *CommandBuilder) Stderr(path string) *CommandBuilder {
+	return command.redirect("2>", path)
+}
+
+func (command *CommandBuilder) StderrToStdout() *CommandBuilder {
+	command.redirects = append(command.redirects, "2>&1")
+	return command
+}
+
+func (command *CommandBuilder) Pipe(next *CommandBuilder) *CommandBuilder {
+	return command.link("|", next)
+}
+
+func (command *CommandBuilder) And(next *CommandBuilder) *CommandBuilder {
+	return command.link("&&", next)
+}
+
+func (command *CommandBuilder) Or(next *CommandBuilder) *CommandBuilder {
+	return command.link("||", next)
+}
+
+func (command *CommandBuilder) String() string {
+	result := command.simple()
+	for _, link := range command.chain {
+		result += " " + link.operator + " " + link.command.group()
+	}
+
+	return result
+}
+
+func (command *CommandBuilder) redirect(
+	operator string,
+	path string,
+) *CommandBuilder {
+	command.redirects = append(command.redirects, operator+" "+quote(path))
+	return command
+}
+
+func (command *CommandBuilder) link(
+	operator string,
+	next *CommandBuilder,
+) *CommandBuilder {
+	command.chain = append(command.chain, commandLink{operator, next})
+	return command
+}
+
+func (command *CommandBuilder) simple() string {
+	parts := []string{}
+
+	// names that are not valid identifiers can not be assigned by the shell
+	// itself, env(1) accepts anything
+	valid := true
+	for _, env := range command.env {
+		valid = valid && variableName.MatchString(env[0])
+	}
+
+	if !valid {
+		parts = append(parts, "env")
+	}
+
+	for _, env := range command.env {
+		if valid {
+			parts = append(parts, env[0]+"="+quote(env[1]))
+		} else {
+			parts = append(parts, quote(env[0]+"="+env[1]))
+		}
+	}
+
+	parts = append(parts, Quote(command.args...))
+	parts = append(parts, command.redirects...)
+
+	return strings.Join(parts, " ")
+}
+
+func (command *CommandBuilder) group() string {
+	if len(command.chain) == 0 {
+		return command.String()
+	}
+
+	return "{ " + command.String() + "; }"
+}
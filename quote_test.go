@@ -0,0 +1,120 @@
+package shell
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testQuoteEvaluate(test *testing.T, command string) string {
+	output, err := exec.Command("/bin/sh", "-c", command).Output()
+	assert.NoError(test, err, command)
+	return string(output)
+}
+
+func TestQuoteKeepsSafeArgument(test *testing.T) {
+	actual := Quote("git", "commit", "-m", "message")
+	assert.Equal(test, "git commit -m message", actual)
+}
+
+func TestQuoteQuotesEmptyArgument(test *testing.T) {
+	actual := Quote("")
+	assert.Equal(test, "''", actual)
+}
+
+func TestQuoteQuotesSingleQuote(test *testing.T) {
+	actual := Quote("it's")
+	assert.Equal(test, `'it'\''s'`, actual)
+}
+
+func TestQuoteQuotesAssignment(test *testing.T) {
+	actual := Quote("A=1")
+	assert.Equal(test, "'A=1'", actual)
+}
+
+func TestQuoteQuotesReservedWord(test *testing.T) {
+	actual := Quote("if")
+	assert.Equal(test, "'if'", actual)
+}
+
+func TestCommandBuildsSimpleCommand(test *testing.T) {
+	actual := Command("git", "commit", "-m", "fix: it's done").String()
+	assert.Equal(test, `git commit -m 'fix: it'\''s done'`, actual)
+}
+
+func TestCommandBuildsEnvPrefix(test *testing.T) {
+	actual := Command("make").Env("CC", "gcc -O2").String()
+	assert.Equal(test, "CC='gcc -O2' make", actual)
+}
+
+func TestCommandBuildsEnvPrefixForInvalidName(test *testing.T) {
+	actual := Command("make").Env("A-B", "1").String()
+	assert.Equal(test, "env 'A-B=1' make", actual)
+}
+
+func TestCommandBuildsRedirections(test *testing.T) {
+	actual := Command("sort").
+		Stdin("in file").
+		Stdout("out").
+		Stderr("/dev/null").
+		String()
+
+	assert.Equal(test, "sort < 'in file' > out 2> /dev/null", actual)
+}
+
+func TestCommandBuildsChain(test *testing.T) {
+	actual := Command("cat", "file").
+		Pipe(Command("grep", "x")).
+		And(Command("echo", "found")).
+		Or(Command("echo", "missing")).
+		String()
+
+	expected := "cat file | grep x && echo found || echo missing"
+	assert.Equal(test, expected, actual)
+}
+
+func TestCommandGroupsNestedChain(test *testing.T) {
+	actual := Command("true").
+		Pipe(Command("false").Or(Command("echo", "ok"))).
+		String()
+
+	assert.Equal(test, "true | { false || echo ok; }", actual)
+}
+
+func TestCommandEvaluatesInShell(test *testing.T) {
+	message := "line 1\nit's $HOME `id` \\ \"quoted\" ünïcödé"
+	command := Command("printf", "%s", message).
+		Env("IGNORED", message).
+		Pipe(Command("cat")).
+		And(Command("printf", "%s", "!"))
+
+	actual := testQuoteEvaluate(test, command.String())
+	assert.Equal(test, message+"!", actual)
+}
+
+func TestCommandEvaluatesEnv(test *testing.T) {
+	command := Command("sh", "-c", `printf %s "$VALUE"`).Env("VALUE", "a'b\nc")
+	actual := testQuoteEvaluate(test, command.String())
+	assert.Equal(test, "a'b\nc", actual)
+}
+
+func FuzzQuote(fuzz *testing.F) {
+	fuzz.Add("simple", "")
+	fuzz.Add("it's", "two words")
+	fuzz.Add("$HOME `id` $(id)", "\\")
+	fuzz.Add("line\nbreak", "\"quoted\"")
+	fuzz.Add("ünïcödé", "-n")
+	fuzz.Add("A=1", "~")
+
+	fuzz.Fuzz(func(test *testing.T, first string, second string) {
+		if strings.ContainsRune(first+second, 0) {
+			test.Skip("arguments can not contain NUL")
+		}
+
+		command := "printf '%s\\000' " + Quote(first, second)
+		actual := testQuoteEvaluate(test, command)
+		assert.Equal(test, first+"\x00"+second+"\x00", actual)
+	})
+}
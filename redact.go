@@ -0,0 +1,166 @@
+package shell
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	redacted = "***"
+)
+
+type redactor struct {
+	secrets []string
+	redact  func(string) string
+
+	// current holds the secrets known only while a command runs, such as the
+	// password fed to sudo from the query; copies of the redactor share it
+	current *atomic.Pointer[[]string]
+}
+
+func newRedactor(secrets []string, redact func(string) string) redactor {
+	redactor := redactor{redact: redact, current: &atomic.Pointer[[]string]{}}
+	for _, secret := range secrets {
+		if secret != "" {
+			redactor.secrets = append(redactor.secrets, secret)
+		}
+	}
+
+	sortSecrets(redactor.secrets)
+	return redactor
+}
+
+// longer secrets go first so that a secret containing another one is masked
+// as a whole
+func sortSecrets(secrets []string) {
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+}
+
+// with masks the secret as well until the returned function is called
+func (redactor redactor) with(secret string) func() {
+	if secret == "" || redactor.current == nil {
+		return func() {}
+	}
+
+	secrets := append([]string{secret}, redactor.secrets...)
+	sortSecrets(secrets)
+	redactor.current.Store(&secrets)
+
+	return func() { redactor.current.Store(nil) }
+}
+
+func (redactor redactor) all() []string {
+	if redactor.current == nil {
+		return redactor.secrets
+	}
+
+	if secrets := redactor.current.Load(); secrets != nil {
+		return *secrets
+	}
+
+	return redactor.secrets
+}
+
+func (redactor redactor) line(line string) string {
+	for _, secret := range redactor.all() {
+		line = strings.ReplaceAll(line, secret, redacted)
+	}
+
+	if redactor.redact != nil {
+		line = redactor.redact(line)
+	}
+
+	return line
+}
+
+func (redactor redactor) error(err error) error {
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) {
+		redactedErr := *syntaxErr
+		redactedErr.Command = redactor.line(syntaxErr.Command)
+		return &redactedErr
+	}
+
+	var violation *PolicyViolation
+	if errors.As(err, &violation) {
+		redactedErr := *violation
+		redactedErr.Command = redactor.line(violation.Command)
+		redactedErr.Reason = redactor.line(violation.Reason)
+		return &redactedErr
+	}
+
+	return err
+}
+
+// cut moves the position the line buffer is trimmed at back to the start of
+// a secret crossing it, including a secret which is still arriving, so the
+// secret is kept whole and masked once the line is sent
+func (redactor redactor) cut(buffer string, cut int) int {
+	secrets := redactor.all()
+	for moved := true; moved; {
+		moved = false
+
+		for _, secret := range secrets {
+			start := cut - len(secret) + 1
+			if start < 0 {
+				start = 0
+			}
+
+			for ; start < cut; start++ {
+				rest := buffer[start:]
+				if strings.HasPrefix(rest, secret) || strings.HasPrefix(secret, rest) {
+					cut = start
+					moved = true
+					break
+				}
+			}
+		}
+	}
+
+	return cut
+}
+
+// fragment masks a piece of a stream which may start or end in the middle of
+// a secret, so the parts of secrets at its edges are masked as well
+func (redactor redactor) fragment(data string) string {
+	head, tail := 0, 0
+
+	for _, secret := range redactor.all() {
+		if len(data) < len(secret) && strings.Contains(secret, data) {
+			return redacted
+		}
+
+		for size := len(secret) - 1; size > head; size-- {
+			if strings.HasPrefix(data, secret[len(secret)-size:]) {
+				head = size
+				break
+			}
+		}
+
+		for size := len(secret) - 1; size > tail; size-- {
+			if strings.HasSuffix(data, secret[:size]) {
+				tail = size
+				break
+			}
+		}
+	}
+
+	if head+tail >= len(data) {
+		return redacted
+	}
+
+	result := redactor.line(data[head : len(data)-tail])
+	if head > 0 {
+		result = redacted + result
+	}
+
+	if tail > 0 {
+		result += redacted
+	}
+
+	return result
+}
@@ -0,0 +1,118 @@
+package shell
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactMasksSecrets(test *testing.T) {
+	redactor := newRedactor([]string{"TOKEN", "", "LONG_TOKEN"}, nil)
+	assert.Equal(test, "*** and ***", redactor.line("LONG_TOKEN and TOKEN"))
+}
+
+func TestRedactAppliesFunc(test *testing.T) {
+	redactor := newRedactor([]string{"TOKEN"}, strings.ToLower)
+	assert.Equal(test, "***: value", redactor.line("TOKEN: VALUE"))
+}
+
+func TestRedactKeepsLineWithoutSecrets(test *testing.T) {
+	redactor := newRedactor(nil, nil)
+	assert.Equal(test, "TOKEN", redactor.line("TOKEN"))
+}
+
+func TestRedactMasksCommandInSyntaxError(test *testing.T) {
+	redactor := newRedactor([]string{"TOKEN"}, nil)
+	err := redactor.error(Validate(`curl -H "TOKEN`))
+
+	var syntaxErr *SyntaxError
+	assert.ErrorAs(test, err, &syntaxErr)
+	assert.Equal(test, `curl -H "***`, syntaxErr.Command)
+	assert.NotContains(test, err.Error(), "TOKEN")
+}
+
+func TestRedactCutKeepsSecretCrossingCut(test *testing.T) {
+	redactor := newRedactor([]string{"TOKEN"}, nil)
+	assert.Equal(test, 3, redactor.cut("123TOKEN456", 5))
+}
+
+func TestRedactCutKeepsArrivingSecret(test *testing.T) {
+	redactor := newRedactor([]string{"TOKEN"}, nil)
+	assert.Equal(test, 3, redactor.cut("123TOK", 4))
+}
+
+func TestRedactCutFollowsOverlappingSecrets(test *testing.T) {
+	redactor := newRedactor([]string{"ABCD", "CDEF"}, nil)
+	assert.Equal(test, 1, redactor.cut("0ABCDEF", 5))
+}
+
+func TestRedactCutKeepsPositionWithoutSecrets(test *testing.T) {
+	redactor := newRedactor([]string{"TOKEN"}, nil)
+	assert.Equal(test, 8, redactor.cut("123TOKEN456", 8))
+	assert.Equal(test, 2, redactor.cut("12345", 2))
+}
+
+func TestShellRedactsSecretSplitBetweenReads(test *testing.T) {
+	state := newConfiguredTestShellState(0, func(shell *shell) {
+		shell.redaction = newRedactor([]string{"TOKEN"}, nil)
+	})
+
+	defer state.shell.close()
+	state.run("COMMAND", func() {
+		state.stdout.Write([]byte("key: TO"))
+		state.stdout.Write([]byte("KEN\n"))
+		state.stderr.Write([]byte("TOKEN\n"))
+		state.stdout.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+	})
+
+	assert.ElementsMatch(test, []string{"OUT: key: ***", "ERR: ***"}, state.args)
+}
+
+func TestShellRedactsSecretCutByLineLimit(test *testing.T) {
+	state := newConfiguredTestShellState(4, func(shell *shell) {
+		shell.redaction = newRedactor([]string{"TOKEN"}, nil)
+	})
+
+	defer state.shell.close()
+	state.run("COMMAND", func() {
+		state.stdout.Write([]byte("12345TO"))
+		state.stdout.Write([]byte("KEN"))
+		state.stdout.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+	})
+
+	assert.Equal(test, []string{"OUT: ***"}, state.args)
+}
+
+func TestShellRedactsSyntaxError(test *testing.T) {
+	state := newConfiguredTestShellState(0, func(shell *shell) {
+		shell.redaction = newRedactor([]string{"TOKEN"}, nil)
+	})
+
+	defer state.shell.close()
+	go io.Copy(io.Discard, state.stdin)
+
+	_, err := state.shell.Run(`echo "TOKEN`, nil)
+	assert.Error(test, err)
+	assert.NotContains(test, err.Error(), "TOKEN")
+}
+
+func TestRedactFragmentMasksSecretEdges(test *testing.T) {
+	redactor := newRedactor([]string{"TOKEN"}, nil)
+	assert.Equal(test, "***: value ***", redactor.fragment("KEN: value TO"))
+	assert.Equal(test, "***", redactor.fragment("OKE"))
+	assert.Equal(test, "a *** b", redactor.fragment("a TOKEN b"))
+}
+
+func TestRedactMasksSecretWhileSet(test *testing.T) {
+	redactor := newRedactor([]string{"TOKEN"}, nil)
+
+	restore := redactor.with("PASS")
+	assert.Equal(test, "*** *** ***", redactor.line("TOKEN PASS PASS"))
+	restore()
+
+	assert.Equal(test, "*** PASS", redactor.line("TOKEN PASS"))
+}
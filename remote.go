@@ -1,8 +1,14 @@
 package shell
 
 import (
+	"errors"
 	"io"
+	"log/slog"
+	"os"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -20,9 +26,26 @@ func (reader reader) Close() error {
 }
 
 type RemoteConfig struct {
-	Address   string
-	Auth      []ssh.AuthMethod
-	LineLimit int
+	Address     string
+	Auth        []ssh.AuthMethod
+	LineLimit   int
+	OutputLimit OutputLimit
+	BufferSize  int
+	SpillDir    string
+	Shell       string
+	Args        []string
+
+	Redact     []string
+	RedactFunc func(string) string
+	Logger     *slog.Logger
+
+	ProbeTimeout     time.Duration
+	GracePeriod      time.Duration
+	InterruptOnError bool
+
+	Become       string
+	BecomeMethod BecomeMethod
+	Password     func() (string, error)
 }
 
 func NewRemote(config RemoteConfig) (*Remote, error) {
@@ -44,10 +67,23 @@ func NewRemote(config RemoteConfig) (*Remote, error) {
 		return nil, err
 	}
 
-	shell := &Remote{client: client}
+	shell := &Remote{client: client, grace: config.GracePeriod}
+	if shell.grace == 0 {
+		shell.grace = defaultGracePeriod
+	}
 
 	shell.limit = config.LineLimit
-	shell.messages = make(chan message, 4096)
+	shell.output = config.OutputLimit
+	shell.interrupt = config.InterruptOnError
+	shell.redaction = newRedactor(config.Redact, config.RedactFunc)
+	if config.Logger != nil {
+		shell.log = config.Logger.With("address", address)
+	}
+	shell.interpreter = interpreter(config.Shell, config.Args)
+	shell.method = config.BecomeMethod
+	shell.password = config.Password
+
+	shell.messages = newQueue(config.BufferSize, config.SpillDir)
 
 	shell.session, err = client.NewSession()
 	if err != nil {
@@ -70,13 +106,46 @@ func NewRemote(config RemoteConfig) (*Remote, error) {
 		return shell, err
 	}
 
-	err = shell.session.Start("/bin/sh")
+	err = shell.session.Start(Quote(shell.interpreter...))
 	if err != nil {
 		return shell, err
 	}
 
+	shell.exited = make(chan struct{})
+	go func() {
+		shell.status = sessionStatus(shell.session.Wait())
+		close(shell.exited)
+	}()
+
+	shell.signal = shell.Signal
+	shell.terminate = shell.terminateSession
 	shell.start()
 
+	if err := shell.probe(config.ProbeTimeout); err != nil {
+		shell.Close()
+		return nil, err
+	}
+
+	shell.pid, err = shell.processID()
+	if err != nil {
+		shell.Close()
+		return nil, err
+	}
+
+	if config.Become != "" {
+		if err := shell.become(config.Become); err != nil {
+			shell.Close()
+			return nil, err
+		}
+	}
+
+	shell.log.Info(
+		"shell: session started",
+		"interpreter", shell.interpreter,
+		"pid", shell.pid,
+		"user", shell.user,
+	)
+
 	return shell, nil
 }
 
@@ -84,24 +153,138 @@ type Remote struct {
 	shell
 	client  *ssh.Client
 	session *ssh.Session
+	grace   time.Duration
+}
+
+var (
+	signalNames = map[syscall.Signal]ssh.Signal{
+		syscall.SIGABRT: ssh.SIGABRT,
+		syscall.SIGALRM: ssh.SIGALRM,
+		syscall.SIGFPE:  ssh.SIGFPE,
+		syscall.SIGHUP:  ssh.SIGHUP,
+		syscall.SIGILL:  ssh.SIGILL,
+		syscall.SIGINT:  ssh.SIGINT,
+		syscall.SIGKILL: ssh.SIGKILL,
+		syscall.SIGPIPE: ssh.SIGPIPE,
+		syscall.SIGQUIT: ssh.SIGQUIT,
+		syscall.SIGSEGV: ssh.SIGSEGV,
+		syscall.SIGTERM: ssh.SIGTERM,
+		syscall.SIGUSR1: ssh.SIGUSR1,
+		syscall.SIGUSR2: ssh.SIGUSR2,
+	}
+)
+
+func sshSignal(signal os.Signal) (ssh.Signal, error) {
+	number, ok := signal.(syscall.Signal)
+	if ok {
+		if name, ok := signalNames[number]; ok {
+			return name, nil
+		}
+	}
+
+	return "", errors.New("shell: unsupported signal " + signal.String())
+}
+
+// The "signal" channel request is delivered to the shell itself rather than
+// to the command it runs, which would end the session, so the command is
+// signalled from a side session.
+func (shell *Remote) Signal(signal os.Signal) error {
+	name, err := sshSignal(signal)
+	if err != nil {
+		return err
+	}
+
+	if shell.pid == 0 {
+		return errors.New("shell: remote process id is unknown")
+	}
+
+	session, err := shell.client.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	argv := signalChildren(name, shell.pid, shell.backgroundPIDs())
+	command := Quote(argv...)
+	if shell.user != "" {
+		input := ""
+		if command, input, err = shell.privileged(shell.user, argv); err != nil {
+			return err
+		}
+
+		session.Stdin = strings.NewReader(input)
+	}
+
+	err = session.Run(command)
+
+	// the command exits with 1 when there is nothing to signal
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitStatus() == 1 {
+		return nil
+	}
+
+	return err
+}
+
+// signalChildren returns the command which signals the process tree below
+// the interpreter like Local.Signal does, leaving out background jobs along
+// with their own trees; it exits with 1 when there is nothing to signal
+func signalChildren(name ssh.Signal, pid int, excluded []int) []string {
+	visit := "echo \"$__shell_child\"; __shell_tree \"$__shell_child\""
+	if len(excluded) > 0 {
+		jobs := []string{}
+		for _, job := range excluded {
+			jobs = append(jobs, strconv.Itoa(job))
+		}
+
+		visit = "case $__shell_child in " + strings.Join(jobs, "|") + ") ;; " +
+			"*) " + visit + ";; esac"
+	}
+
+	return []string{defaultShell, "-c", "__shell_tree() { " +
+		"for __shell_child in $(pgrep -P \"$1\"); do " + visit + "; done; }; " +
+		"pids=$(__shell_tree " + strconv.Itoa(pid) + "); " +
+		"[ -n \"$pids\" ] || exit 1; " +
+		"kill -" + string(name) + " $pids 2> /dev/null; :"}
 }
 
 func (shell *Remote) Close() error {
-	closeErr := shell.close()
+	return shell.close()
+}
+
+func (shell *Remote) terminateSession() error {
+	select {
+	case <-shell.exited:
+	case <-time.After(shell.grace):
+		shell.Signal(syscall.SIGTERM)
+
+		select {
+		case <-shell.exited:
+		case <-time.After(shell.grace):
+		}
+	}
+
 	sessionCloseErr := shell.session.Close()
 	clientCloseErr := shell.client.Close()
+	<-shell.exited
 
-	if closeErr != nil {
-		return closeErr
+	if sessionCloseErr != nil && sessionCloseErr != io.EOF {
+		return sessionCloseErr
 	}
 
-	if sessionCloseErr != nil {
-		return sessionCloseErr
+	return clientCloseErr
+}
+
+func sessionStatus(err error) int {
+	if err == nil {
+		return 0
 	}
 
-	if clientCloseErr != nil {
-		return clientCloseErr
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
 	}
 
-	return nil
+	return -1
 }
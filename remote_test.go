@@ -1,7 +1,12 @@
 package shell
 
 import (
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/ssh"
@@ -120,3 +125,177 @@ func TestRemoteExitsWithoutErrorWhileExecutingCommand(test *testing.T) {
 	err = shell.Close()
 	assert.NoError(test, err)
 }
+
+func TestRemoteRunsCommandInConfiguredShell(test *testing.T) {
+	config := getTestRemoteConfig()
+	config.Shell = "/bin/bash"
+	config.Args = []string{"-l"}
+
+	shell, err := NewRemote(config)
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	state := testRemoteState{shell: shell}
+	status, err := state.shell.Run("echo $BASH_VERSION | cut -c 1", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.NotEqual(test, "OUT: ", state.args[0])
+}
+
+func TestRemoteSignalInterruptsRunningCommand(test *testing.T) {
+	state := newTestRemoteState()
+	defer state.shell.Close()
+
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		state.shell.Signal(syscall.SIGINT)
+	}()
+
+	status, err := state.shell.Run("sleep 100", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 130, status)
+
+	status, err = state.shell.Run("echo ALIVE", state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, "OUT: ALIVE", state.args[0])
+}
+
+func TestRemoteSignalWithoutRunningCommand(test *testing.T) {
+	state := newTestRemoteState()
+	defer state.shell.Close()
+
+	err := state.shell.Signal(syscall.SIGINT)
+	assert.NoError(test, err)
+}
+
+func TestRemoteConvertsSignalName(test *testing.T) {
+	name, err := sshSignal(syscall.SIGTERM)
+	assert.NoError(test, err)
+	assert.Equal(test, ssh.SIGTERM, name)
+}
+
+func TestRemoteReturnsErrorOnUnsupportedSignal(test *testing.T) {
+	_, err := sshSignal(os.Signal(syscall.Signal(64)))
+	assert.Error(test, err)
+}
+
+func TestRemoteSignalsChildrenExceptJobs(test *testing.T) {
+	argv := signalChildren(ssh.SIGINT, 10, nil)
+	assert.Equal(test, []string{defaultShell, "-c"}, argv[:2])
+	assert.Contains(test, argv[2], "$(pgrep -P \"$1\")")
+	assert.Contains(test, argv[2], "pids=$(__shell_tree 10)")
+	assert.Contains(test, argv[2], "kill -INT $pids")
+	assert.NotContains(test, argv[2], "case")
+
+	argv = signalChildren(ssh.SIGINT, 10, []int{11, 12})
+	assert.Contains(test, argv[2], "case $__shell_child in 11|12) ;;")
+}
+
+func TestSignalChildrenSignalsWholeTree(test *testing.T) {
+	shell := exec.Command(defaultShell, "-c", "sh -c 'sleep 30; :' & sleep 30 & wait")
+	assert.NoError(test, shell.Start())
+	defer shell.Process.Kill()
+
+	time.Sleep(200 * time.Millisecond)
+
+	argv := signalChildren(ssh.SIGTERM, shell.Process.Pid, nil)
+	assert.NoError(test, exec.Command(argv[0], argv[1:]...).Run())
+
+	done := make(chan error)
+	go func() { done <- shell.Wait() }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		test.Fatal("process tree was not signalled")
+	}
+
+	err := exec.Command(argv[0], argv[1:]...).Run()
+	var exitErr *exec.ExitError
+	assert.ErrorAs(test, err, &exitErr)
+	assert.Equal(test, 1, exitErr.ExitCode())
+}
+
+func TestRemoteCloseIsIdempotent(test *testing.T) {
+	shell, err := NewRemote(getTestRemoteConfig())
+	assert.NoError(test, err)
+
+	assert.NoError(test, shell.Close())
+	assert.NoError(test, shell.Close())
+	assert.Equal(test, 0, shell.ExitStatus())
+}
+
+func TestRemoteReportsExitStatus(test *testing.T) {
+	shell, err := NewRemote(getTestRemoteConfig())
+	assert.NoError(test, err)
+
+	_, err = shell.Run("exit 3", nil)
+	assert.Error(test, err)
+
+	assert.NoError(test, shell.Close())
+	assert.Equal(test, 3, shell.ExitStatus())
+}
+
+func TestRemotePingsShell(test *testing.T) {
+	state := newTestRemoteState()
+	defer state.shell.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(test, state.shell.Ping(ctx))
+	assert.True(test, state.shell.Alive())
+}
+
+func TestRemoteDoneIsClosedOnExit(test *testing.T) {
+	state := newTestRemoteState()
+	defer state.shell.Close()
+
+	state.shell.Run("exit 0", nil)
+
+	select {
+	case <-state.shell.Done():
+	case <-time.After(5 * time.Second):
+		test.Fatal("done channel is not closed")
+	}
+
+	assert.False(test, state.shell.Alive())
+}
+
+func TestRemoteRunsCommandAsUser(test *testing.T) {
+	config := getTestRemoteConfig()
+	config.BecomeMethod = Su
+	shell, err := NewRemote(config)
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	output := []string{}
+	status, err := shell.RunAs("nobody", "id -un", func(
+		kind MessageType,
+		line string,
+	) error {
+		output = append(output, line)
+		return nil
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"nobody"}, output)
+}
+
+func TestRemoteStartsJob(test *testing.T) {
+	state := newTestRemoteState()
+	defer state.shell.Close()
+
+	job, err := state.shell.Start("echo STARTED; sleep 100")
+	assert.NoError(test, err)
+
+	assert.Equal(test, Line{StdOut, "STARTED"}, <-job.Lines())
+	time.Sleep(500 * time.Millisecond)
+	assert.NoError(test, job.Cancel())
+
+	status, err := job.Wait()
+	assert.NoError(test, err)
+	assert.Equal(test, 130, status)
+}
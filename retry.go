@@ -0,0 +1,132 @@
+package shell
+
+import (
+	"math/rand"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// RetryConfig retries a command failing with one of Statuses or writing a
+// line matching one of StdErr; when both are empty any non-zero status is
+// retried. The delay starts at Backoff, doubles up to MaxBackoff and is
+// shortened by a random part of up to Jitter of it; BeforeRetry stops
+// retrying by returning an error.
+type RetryConfig struct {
+	Attempts    int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Jitter      float64
+	Statuses    []int
+	StdErr      []*regexp.Regexp
+	BeforeRetry func(attempt int, status int) error
+}
+
+type Retrying struct {
+	shell  Shell
+	config RetryConfig
+}
+
+func NewRetrying(shell Shell, config RetryConfig) *Retrying {
+	if config.Attempts < 1 {
+		config.Attempts = 1
+	}
+
+	return &Retrying{shell: shell, config: config}
+}
+
+func (shell *Retrying) Run(
+	command string,
+	handler func(MessageType, string) error,
+) (int, error) {
+	return shell.RunAttempts(command, func(
+		attempt int,
+		kind MessageType,
+		line string,
+	) error {
+		if handler == nil {
+			return nil
+		}
+
+		return handler(kind, line)
+	})
+}
+
+// RunAttempts runs command like Run and passes the attempt number starting
+// from 1 to handler; the status of the last attempt is returned
+func (shell *Retrying) RunAttempts(
+	command string,
+	handler func(attempt int, kind MessageType, line string) error,
+) (int, error) {
+	delay := shell.config.Backoff
+	for attempt := 1; ; attempt++ {
+		matched := false
+		status, err := shell.shell.Run(command, func(
+			kind MessageType,
+			line string,
+		) error {
+			if kind == StdErr && shell.matches(line) {
+				matched = true
+			}
+
+			if handler == nil {
+				return nil
+			}
+
+			return handler(attempt, kind, line)
+		})
+
+		if err != nil || status == 0 || attempt >= shell.config.Attempts ||
+			!shell.retryable(status, matched) {
+			return status, err
+		}
+
+		if shell.config.BeforeRetry != nil {
+			if err := shell.config.BeforeRetry(attempt+1, status); err != nil {
+				return status, err
+			}
+		}
+
+		time.Sleep(shell.jitter(delay))
+
+		delay *= 2
+		if shell.config.MaxBackoff > 0 && delay > shell.config.MaxBackoff {
+			delay = shell.config.MaxBackoff
+		}
+	}
+}
+
+func (shell *Retrying) matches(line string) bool {
+	for _, pattern := range shell.config.StdErr {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (shell *Retrying) retryable(status int, matched bool) bool {
+	if len(shell.config.Statuses) == 0 && len(shell.config.StdErr) == 0 {
+		return true
+	}
+
+	return matched || slices.Contains(shell.config.Statuses, status)
+}
+
+func (shell *Retrying) jitter(delay time.Duration) time.Duration {
+	if shell.config.Jitter <= 0 {
+		return delay
+	}
+
+	return delay - time.Duration(rand.Float64()*shell.config.Jitter*float64(delay))
+}
+
+func (shell *Retrying) Signal(signal os.Signal) error {
+	return shell.shell.Signal(signal)
+}
+
+func (shell *Retrying) Close() error {
+	return shell.shell.Close()
+}
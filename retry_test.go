@@ -0,0 +1,106 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const retryTestCommand = "COUNT=$((COUNT+1)); echo $COUNT; " +
+	"[ $COUNT -ge 3 ] || { echo 'temporary failure' 1>&2; (exit 100); }"
+
+func TestRetryRunsUntilSuccess(test *testing.T) {
+	state := newTestLocalState()
+	retries := []string{}
+	shell := NewRetrying(state.shell, RetryConfig{
+		Attempts: 5,
+		Backoff:  time.Millisecond,
+		Jitter:   0.5,
+		BeforeRetry: func(attempt int, status int) error {
+			retries = append(retries, fmt.Sprintf("%d: %d", attempt, status))
+			return nil
+		},
+	})
+
+	defer shell.Close()
+
+	lines := []string{}
+	status, err := shell.RunAttempts(retryTestCommand, func(
+		attempt int,
+		kind MessageType,
+		line string,
+	) error {
+		lines = append(lines, fmt.Sprintf("%d %d %s", attempt, kind, line))
+		return nil
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"2: 100", "3: 100"}, retries)
+	assert.ElementsMatch(test, []string{
+		"1 0 1",
+		"1 2 temporary failure",
+		"2 0 2",
+		"2 2 temporary failure",
+		"3 0 3",
+	}, lines)
+}
+
+func TestRetryStopsAfterAttempts(test *testing.T) {
+	state := newTestLocalState()
+	shell := NewRetrying(state.shell, RetryConfig{Attempts: 2})
+	defer shell.Close()
+
+	status, err := shell.Run(retryTestCommand, state.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 100, status)
+	assert.ElementsMatch(test, []string{
+		"OUT: 1",
+		"ERR: temporary failure",
+		"OUT: 2",
+		"ERR: temporary failure",
+	}, state.args)
+}
+
+func TestRetryOnlyRetryableFailures(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	status, _ := NewRetrying(state.shell, RetryConfig{
+		Attempts: 3,
+		Statuses: []int{1},
+	}).Run(retryTestCommand, nil)
+
+	assert.Equal(test, 100, status)
+
+	status, _ = NewRetrying(state.shell, RetryConfig{
+		Attempts: 3,
+		StdErr:   []*regexp.Regexp{regexp.MustCompile(`^temporary`)},
+	}).Run(retryTestCommand, nil)
+
+	assert.Equal(test, 0, status)
+
+	status, _ = state.shell.Run("echo $COUNT", state.handler)
+	assert.Equal(test, []string{"OUT: 3"}, state.args)
+}
+
+func TestRetryStopsOnHookError(test *testing.T) {
+	state := newTestLocalState()
+	hookErr := errors.New("stop")
+	shell := NewRetrying(state.shell, RetryConfig{
+		Attempts: 3,
+		BeforeRetry: func(attempt int, status int) error {
+			return hookErr
+		},
+	})
+
+	defer shell.Close()
+
+	status, err := shell.Run(retryTestCommand, nil)
+	assert.Equal(test, 100, status)
+	assert.Equal(test, hookErr, err)
+}
@@ -0,0 +1,177 @@
+package shell
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	scriptFunction = "__shell_script"
+)
+
+var (
+	scriptMarkerRegexp = regexp.MustCompile(
+		`__SHELL_SCRIPT_((?:LINE|FAILED)_\d+(?:_\d+)?)__`,
+	)
+)
+
+type ScriptConfig struct {
+	ErrExit  bool
+	Progress func(line int, text string)
+}
+
+type ScriptError struct {
+	Line   int
+	Text   string
+	Status int
+	Output []Line
+}
+
+func (err *ScriptError) Error() string {
+	return fmt.Sprintf(
+		"shell: script failed at line %d with status %d: %q",
+		err.Line,
+		err.Status,
+		err.Text,
+	)
+}
+
+type statement struct {
+	text string
+	line int
+}
+
+// statements splits script into top level commands, each of them starts on
+// its own line and may span several lines
+func statements(script string) ([]statement, error) {
+	lexer := newLexer(script, 0, false)
+	if err := lexer.run(); err != nil {
+		return nil, err
+	}
+
+	result := []statement{}
+	start := 0
+	for _, end := range append(lexer.boundaries, len(script)) {
+		if end <= start {
+			continue
+		}
+
+		text := script[start:end]
+		tokens, _ := lex(text)
+		for _, token := range tokens {
+			if token.kind != operatorToken || token.value != "\n" {
+				line := strings.Count(script[:start], "\n") + 1
+				result = append(result, statement{text, line})
+				break
+			}
+		}
+
+		start = end
+	}
+
+	return result, nil
+}
+
+// RunScript runs every top level command of the script in the session and
+// reports the line that failed along with the output it produced; with
+// ErrExit the script stops at the first failed command like with set -e. A
+// multi-line command such as if or for counts as one, so a failure inside it
+// is reported at its first line with the whole command as Text
+func (shell *shell) RunScript(
+	script string,
+	config ScriptConfig,
+	handler func(MessageType, string) error,
+) (int, error) {
+	parts, err := statements(script)
+	if err != nil {
+		return -1, shell.redaction.error(err)
+	}
+
+	texts := map[int]string{}
+
+	// markers are printed before each command, so its $? is restored by a
+	// function call to keep it visible to the command
+	body := "__shell_script_status=0\n"
+	for _, part := range parts {
+		texts[part.line] = strings.TrimRight(part.text, "\n")
+
+		line := strconv.Itoa(part.line)
+		failure := ":"
+		if config.ErrExit {
+			failure = "return \"$__shell_script_status\""
+		}
+
+		body += "printf '__SHELL_SCRIPT_LINE_%s__\\n' " + line + "; " +
+			"printf '__SHELL_SCRIPT_LINE_%s__\\n' " + line + " 1>&2; " +
+			"__shell_script_return \"$__shell_script_status\"\n" +
+			strings.TrimRight(part.text, "\n") + "\n" +
+			"__shell_script_status=$?; " +
+			"if [ \"$__shell_script_status\" -ne 0 ]; then " +
+			"printf '__SHELL_SCRIPT_FAILED_%s_%s__\\n' " + line +
+			" \"$__shell_script_status\"; " + failure + "; fi\n"
+	}
+
+	body += "return \"$__shell_script_status\"\n"
+
+	current := map[MessageType]int{}
+	output := map[int][]Line{}
+	failed, failedStatus := 0, 0
+
+	tracker := func(kind MessageType, text string) error {
+		if kind != stdoutMarker && kind != stderrMarker {
+			line := current[kind]
+			output[line] = append(output[line], Line{kind, text})
+			if handler == nil {
+				return nil
+			}
+
+			return handler(kind, text)
+		}
+
+		fields := strings.Split(text, "_")
+		number, _ := strconv.Atoi(fields[1])
+		if fields[0] == "FAILED" {
+			failed = number
+			failedStatus, _ = strconv.Atoi(fields[2])
+			return nil
+		}
+
+		if kind == stdoutMarker {
+			current[StdOut] = number
+			if config.Progress != nil {
+				config.Progress(number, shell.redaction.line(texts[number]))
+			}
+		} else {
+			current[StdErr] = number
+		}
+
+		return nil
+	}
+
+	query := "__shell_script_return() { return \"$1\"; }\n" +
+		scriptFunction + "() {\n" + body + "}\n" + scriptFunction + "\n" +
+		epilogue
+
+	result, err := shell.execute(script, query, tracker, func(<-chan struct{}) error {
+		shell.scripting.Store(true)
+		return nil
+	})
+
+	status := int(result.Status)
+	if err != nil {
+		return status, err
+	}
+
+	if status != 0 && failed != 0 && status == failedStatus {
+		return status, &ScriptError{
+			Line:   failed,
+			Text:   shell.redaction.line(texts[failed]),
+			Status: failedStatus,
+			Output: output[failed],
+		}
+	}
+
+	return status, nil
+}
@@ -0,0 +1,147 @@
+package shell
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatementsSplitsTopLevelCommands(test *testing.T) {
+	script := "echo 1\n" +
+		"\n" +
+		"# comment\n" +
+		"if true; then\n" +
+		"  echo 2\n" +
+		"fi\n" +
+		"cat <<EOF\n" +
+		"text\n" +
+		"EOF\n" +
+		"true &&\n" +
+		"  false\n" +
+		"f()\n" +
+		"{ echo 3; }\n" +
+		"echo 4"
+
+	parts, err := statements(script)
+	assert.NoError(test, err)
+	assert.Equal(test, []statement{
+		{"echo 1\n", 1},
+		{"if true; then\n  echo 2\nfi\n", 4},
+		{"cat <<EOF\ntext\nEOF\n", 7},
+		{"true &&\n  false\n", 10},
+		{"f()\n{ echo 3; }\n", 12},
+		{"echo 4", 14},
+	}, parts)
+}
+
+func TestStatementsReturnsSyntaxError(test *testing.T) {
+	_, err := statements("if true; then\necho 1\n")
+	var syntaxErr *SyntaxError
+	assert.ErrorAs(test, err, &syntaxErr)
+}
+
+func TestShellKeepsScriptMarkersInCommandOutput(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+	state.run("COMMAND", func() {
+		state.stdout.Write([]byte("A__SHELL_SCRIPT_LINE_1__B\n"))
+		state.stdout.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+	})
+
+	assert.Equal(
+		test,
+		[]string{"OUT: A__SHELL_SCRIPT_LINE_1__B"},
+		state.args,
+	)
+
+	local := newTestLocalState()
+	defer local.shell.Close()
+
+	_, err := local.shell.Run("echo out__SHELL_SCRIPT_LINE_1__x", local.handler)
+	assert.NoError(test, err)
+	assert.Equal(test, []string{"OUT: out__SHELL_SCRIPT_LINE_1__x"}, local.args)
+}
+
+func TestScriptRunsInSession(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	progress := []int{}
+	status, err := state.shell.RunScript(
+		"cd /tmp\nVALUE=1\nfalse\necho $?\n",
+		ScriptConfig{Progress: func(line int, text string) {
+			progress = append(progress, line)
+		}},
+		state.handler,
+	)
+
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"OUT: 1"}, state.args)
+	assert.Equal(test, []int{1, 2, 3, 4}, progress)
+
+	state.args = nil
+	state.shell.Run("pwd; echo $VALUE", state.handler)
+	assert.Equal(test, []string{"OUT: /tmp", "OUT: 1"}, state.args)
+}
+
+func TestScriptStopsOnFailureWithErrExit(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	script := "echo FIRST\n" +
+		"if false; then echo SKIPPED; fi\n" +
+		"echo PARTIAL\n" +
+		"sh -c 'echo FAILING; exit 3'\n" +
+		"echo NEVER\n"
+
+	status, err := state.shell.RunScript(
+		script,
+		ScriptConfig{ErrExit: true},
+		state.handler,
+	)
+
+	assert.Equal(test, 3, status)
+	assert.Equal(test, []string{"OUT: FIRST", "OUT: PARTIAL", "OUT: FAILING"}, state.args)
+
+	var scriptErr *ScriptError
+	assert.ErrorAs(test, err, &scriptErr)
+	assert.Equal(test, 4, scriptErr.Line)
+	assert.Equal(test, "sh -c 'echo FAILING; exit 3'", scriptErr.Text)
+	assert.Equal(test, 3, scriptErr.Status)
+	assert.Equal(test, []Line{{StdOut, "FAILING"}}, scriptErr.Output)
+
+	status, err = state.shell.Run("echo ALIVE", nil)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+}
+
+func TestScriptReportsLastFailedLine(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	status, err := state.shell.RunScript(
+		"false\necho CONTINUED\nls /nonexistent",
+		ScriptConfig{},
+		nil,
+	)
+
+	assert.NotEqual(test, 0, status)
+
+	var scriptErr *ScriptError
+	assert.ErrorAs(test, err, &scriptErr)
+	assert.Equal(test, 3, scriptErr.Line)
+	assert.Len(test, scriptErr.Output, 1)
+	assert.Equal(test, StdErr, scriptErr.Output[0].Type)
+}
+
+func TestScriptRedactsFailedLine(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{Redact: []string{"s3cr3t"}})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	_, err = shell.RunScript("test s3cr3t = x", ScriptConfig{}, nil)
+	assert.Error(test, err)
+	assert.NotContains(test, err.Error(), "s3cr3t")
+}
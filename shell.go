@@ -1,10 +1,37 @@
 package shell
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultShell        = "/bin/sh"
+	defaultProbeTimeout = 5 * time.Second
+	interruptInterval   = 100 * time.Millisecond
+
+	// printf and fd duplication are the only things required from the
+	// interpreter, both are available in any POSIX shell
+	epilogue = "__shell_exit_status=$?; " +
+		"printf '__SHELL_EXIT_STATUS_%s__' \"$__shell_exit_status\"; " +
+		"printf '__SHELL_EXIT_STATUS_%s__' \"$__shell_exit_status\" 1>&2\n"
+)
+
+var (
+	ErrUnsupportedShell = errors.New("shell: interpreter does not support " +
+		"exit status protocol")
+	ErrClosed = errors.New("shell: closed")
 )
 
 type MessageType int
@@ -15,26 +42,56 @@ const (
 	StdErr
 	stderrComplete
 	fatal
+	stdoutMarker
+	stderrMarker
 )
 
 type message struct {
-	kind    MessageType
-	message string
-	err     error
+	kind      MessageType
+	message   string
+	err       error
+	truncated bool
 }
 
 type shell struct {
-	stdin  io.WriteCloser
-	stdout io.ReadCloser
-	stderr io.ReadCloser
-	limit  int
-
-	messages chan message
-	closed   bool
+	stdin     io.WriteCloser
+	stdout    io.ReadCloser
+	stderr    io.ReadCloser
+	limit     int
+	output    OutputLimit
+	interrupt bool
+	redaction redactor
+	log       *slog.Logger
+	commands  atomic.Uint64
+
+	interpreter []string
+	pid         int
+	user        string
+	method      BecomeMethod
+	password    func() (string, error)
+
+	messages   *queue
+	background background
+	closing    chan struct{}
+	readers    sync.WaitGroup
+	running    sync.Mutex
+	scripting  atomic.Bool
+	unlimited  bool
+	expecter   atomic.Pointer[expecter]
+	failed     atomic.Bool
+
+	terminate func() error
+	signal    func(os.Signal) error
+	exited    chan struct{}
+	status    int
+
+	closeOnce sync.Once
+	closeErr  error
 }
 
 type Shell interface {
 	Run(command string, handler func(MessageType, string) error) (int, error)
+	Signal(signal os.Signal) error
 	Close() error
 }
 
@@ -42,27 +99,237 @@ func (shell *shell) Run(
 	command string,
 	handler func(MessageType, string) error,
 ) (int, error) {
-	query := strings.TrimRight(command, "\n") + "\n" +
-		"echo -n __SHELL_EXIT_STATUS_$?__ | tee /dev/stderr\n"
+	result, err := shell.Exec(command, handler)
+	return int(result.Status), err
+}
+
+func (shell *shell) Exec(
+	command string,
+	handler func(MessageType, string) error,
+) (Result, error) {
+	if err := Validate(command); err != nil {
+		return Result{Status: -1}, shell.redaction.error(err)
+	}
+
+	query := strings.TrimRight(command, "\n") + "\n" + epilogue
+	return shell.execute(command, query, handler, nil)
+}
 
+// command is what gets logged, query may carry credentials and is written to
+// the interpreter as is; begin is called once the interpreter is free and may
+// still refuse to run the query
+func (shell *shell) execute(
+	command string,
+	query string,
+	handler func(MessageType, string) error,
+	begin func(done <-chan struct{}) error,
+) (Result, error) {
+	select {
+	case <-shell.closing:
+		return Result{Status: -1}, ErrClosed
+	default:
+	}
+
+	shell.running.Lock()
+	defer shell.running.Unlock()
+
+	// done is closed before the next command may start, so the command is
+	// never interrupted by a signal meant for the previous one
+	done := make(chan struct{})
+	defer close(done)
+
+	shell.scripting.Store(false)
+	shell.unlimited = false
+	shell.expecter.Store(nil)
+	if begin != nil {
+		if err := begin(done); err != nil {
+			return Result{Status: -1}, err
+		}
+	}
+
+	id := shell.commands.Add(1)
+	log := shell.log.With("id", id)
+	log.Info("shell: command started", "command", shell.redaction.line(command))
+	log.Debug(
+		"shell: write",
+		"bytes", len(query),
+		"query", shell.redaction.line(query),
+	)
+
+	started := time.Now()
 	if _, err := shell.stdin.Write([]byte(query)); err != nil {
-		return -1, err
+		log.Error("shell: write failed", "err", err)
+		return Result{Status: -1}, &TransportError{Err: err}
 	}
 
-	result, err := shell.wait(handler)
+	result, err := shell.wait(handler, done)
+	log.Info(
+		"shell: command finished",
+		"status", int(result.Status),
+		"truncated", result.Truncated,
+		"duration", time.Since(started),
+		"err", shell.redaction.error(err),
+	)
+
 	return result, err
 }
 
+func (shell *shell) Ping(ctx context.Context) error {
+	result := make(chan error, 1)
+	go func() {
+		status, err := shell.Run(":", nil)
+		if err == nil && status != 0 {
+			err = fmt.Errorf("shell: ping returned status %d", status)
+		}
+
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-shell.exited:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (shell *shell) Alive() bool {
+	if shell.failed.Load() {
+		return false
+	}
+
+	select {
+	case <-shell.closing:
+		return false
+	case <-shell.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+func (shell *shell) Done() <-chan struct{} {
+	return shell.exited
+}
+
+func (shell *shell) probe(timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	type probeResult struct {
+		status int
+		err    error
+	}
+
+	result := make(chan probeResult, 1)
+	go func() {
+		status, err := shell.Run("(exit 3)", nil)
+		result <- probeResult{status, err}
+	}()
+
+	select {
+	case result := <-result:
+		if result.err != nil {
+			return fmt.Errorf("%w: %v", ErrUnsupportedShell, result.err)
+		}
+
+		if result.status != 3 {
+			return fmt.Errorf(
+				"%w: unexpected exit status %d",
+				ErrUnsupportedShell,
+				result.status,
+			)
+		}
+	case <-time.After(timeout):
+		return fmt.Errorf("%w: no response in %s", ErrUnsupportedShell, timeout)
+	}
+
+	return nil
+}
+
+func (shell *shell) processID() (int, error) {
+	output := ""
+	status, err := shell.Run("echo $$", func(kind MessageType, line string) error {
+		if kind == StdOut {
+			output = line
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return -1, err
+	}
+
+	if status != 0 {
+		return -1, fmt.Errorf("shell: failed to get process id, status %d", status)
+	}
+
+	return strconv.Atoi(output)
+}
+
 func (shell *shell) start() {
+	if shell.log == nil {
+		shell.log = slog.New(slog.DiscardHandler)
+	}
+
+	shell.closing = make(chan struct{})
+	shell.readers.Add(2)
+
 	go func() {
-		shell.read(shell.stdout, StdOut, stdoutComplete)
+		defer shell.readers.Done()
+		shell.read(shell.stdout, StdOut, stdoutComplete, "stdout")
 	}()
 
 	go func() {
-		shell.read(shell.stderr, StdErr, stderrComplete)
+		defer shell.readers.Done()
+		shell.read(shell.stderr, StdErr, stderrComplete, "stderr")
 	}()
 }
 
+func (shell *shell) send(message message) {
+	for {
+		changed := shell.messages.wait()
+		ok, err := shell.messages.push(message)
+		if err != nil {
+			shell.log.Error("shell: failed to spill output", "err", err)
+		}
+
+		if ok {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-shell.closing:
+			return
+		}
+	}
+}
+
+func (shell *shell) receive() (message, bool, error) {
+	for {
+		changed := shell.messages.wait()
+		message, ok, err := shell.messages.pop()
+		if ok || err != nil {
+			return message, ok, err
+		}
+
+		if shell.messages.drained() {
+			return message, false, nil
+		}
+
+		<-changed
+	}
+}
+
+func (shell *shell) Backlog() Backlog {
+	return shell.messages.backlog()
+}
+
 var (
 	exitStatusRegexp = regexp.MustCompile(`__SHELL_EXIT_STATUS_(\w*)__`)
 )
@@ -71,73 +338,170 @@ func (shell *shell) read(
 	reader io.Reader,
 	kind MessageType,
 	comlete MessageType,
+	stream string,
 ) {
 	buffer := ""
+	trimmed := false
+	log := shell.log.With("stream", stream)
 
 	for {
 		line := make([]byte, 1024)
 		count, err := reader.Read(line)
 
-		if shell.closed {
-			break
-		}
-
 		if err != nil {
-			shell.messages <- message{fatal, "", err}
+			log.Debug("shell: read failed", "err", err)
+			shell.failed.Store(true)
+			shell.send(message{fatal, "", err, false})
 			break
 		}
 
+		log.Debug(
+			"shell: read",
+			"id", shell.commands.Load(),
+			"bytes", count,
+			"data", shell.redaction.fragment(string(line[:count])),
+		)
+
 		buffer += string(line[:count])
+		if expecter := shell.expecter.Load(); expecter != nil {
+			expecter.feed(kind, string(line[:count]))
+		}
 
 		matches := exitStatusRegexp.FindStringSubmatch(buffer)
 		if len(matches) > 0 {
+			log.Debug(
+				"shell: exit status detected",
+				"id", shell.commands.Load(),
+				"status", matches[1],
+			)
+
 			parts := strings.SplitN(buffer, matches[0], 2)
 
 			lines := strings.Split(strings.TrimRight(parts[0], "\n"), "\n")
-			for _, line := range lines {
+			for index, line := range lines {
 				if len(line) > 0 {
-					shell.messages <- message{kind, line, nil}
+					shell.emit(kind, line, trimmed && index == 0)
 				}
 			}
 
-			shell.messages <- message{comlete, matches[1], nil}
+			shell.send(message{comlete, matches[1], nil, false})
 			buffer = parts[1]
+			trimmed = false
 		} else if strings.Contains(buffer, "\n") {
 			lines := strings.Split(buffer, "\n")
-			for _, line := range lines[:len(lines)-1] {
-				shell.messages <- message{kind, line, nil}
+			for index, line := range lines[:len(lines)-1] {
+				shell.emit(kind, line, trimmed && index == 0)
 			}
 
 			buffer = lines[len(lines)-1]
+			trimmed = false
 		}
 
-		if shell.limit != 0 && len(buffer) > shell.limit {
-			buffer = buffer[len(buffer)-shell.limit:]
+		// exit status marker which is still arriving is never trimmed
+		if shell.limit != 0 {
+			content, marker := buffer, ""
+			if status := partialStatus(buffer); status != -1 {
+				content, marker = buffer[:status], buffer[status:]
+			}
+
+			if len(content) > shell.limit {
+				cut := shell.redaction.cut(content, len(content)-shell.limit)
+				if cut > 0 {
+					content = content[cut:]
+					trimmed = true
+				}
+			}
+
+			buffer = content + marker
 		}
 	}
 
 }
 
-func (shell *shell) wait(handler func(MessageType, string) error) (int, error) {
-	result := "-1"
+// emit sends a line of output with script markers taken out of it when a
+// script runs; output of other commands is sent as is
+func (shell *shell) emit(kind MessageType, line string, truncated bool) {
+	marker := stdoutMarker
+	if kind == StdErr {
+		marker = stderrMarker
+	}
+
+	var location []int
+	if shell.scripting.Load() {
+		location = scriptMarkerRegexp.FindStringSubmatchIndex(line)
+	}
+
+	if location == nil {
+		shell.send(message{kind, shell.redaction.line(line), nil, truncated})
+		return
+	}
+
+	for location != nil {
+		if location[0] > 0 {
+			text := shell.redaction.line(line[:location[0]])
+			shell.send(message{kind, text, nil, truncated})
+			truncated = false
+		}
+
+		shell.send(message{marker, line[location[2]:location[3]], nil, false})
+		line = line[location[1]:]
+		location = scriptMarkerRegexp.FindStringSubmatchIndex(line)
+	}
+
+	if len(line) > 0 {
+		shell.send(message{kind, shell.redaction.line(line), nil, truncated})
+	}
+}
+
+func (shell *shell) wait(
+	handler func(MessageType, string) error,
+	done <-chan struct{},
+) (Result, error) {
+	status := "-1"
 	var handlerErr error
 
 	stdoutCompleted := false
 	stderrCompleted := false
 
+	truncated := false
+	limited := false
+	limiter := &limiter{limit: shell.output}
+	if shell.unlimited {
+		limiter.limit = OutputLimit{}
+	}
+	deliver := func(message message) {
+		truncated = truncated || message.truncated
+		if handler != nil && handlerErr == nil {
+			err := handler(message.kind, message.message)
+			if err != nil {
+				handlerErr = err
+				shell.abort(err, done)
+			}
+		}
+	}
+
 	for {
-		message, ok := <-shell.messages
+		message, ok, err := shell.receive()
+		if err != nil {
+			return Result{Status: -1}, &TransportError{Err: err}
+		}
+
 		if !ok {
-			break
+			return Result{Status: -1}, ErrClosed
 		}
 
 		if message.kind == fatal || message.err != nil {
-			return -1, message.err
+			select {
+			case <-shell.closing:
+				return Result{Status: -1}, ErrClosed
+			default:
+				return Result{Status: -1}, &TransportError{Err: message.err}
+			}
 		}
 
 		if message.kind == stdoutComplete {
 			stdoutCompleted = true
-			result = message.message
+			status = message.message
 			if stderrCompleted {
 				break
 			}
@@ -147,7 +511,7 @@ func (shell *shell) wait(handler func(MessageType, string) error) (int, error) {
 
 		if message.kind == stderrComplete {
 			stderrCompleted = true
-			result = message.message
+			status = message.message
 			if stdoutCompleted {
 				break
 			}
@@ -163,47 +527,128 @@ func (shell *shell) wait(handler func(MessageType, string) error) (int, error) {
 			continue
 		}
 
-		if handler != nil && handlerErr == nil {
-			err := handler(message.kind, message.message)
-			if err != nil {
-				handlerErr = err
-			}
+		if message.kind == stdoutMarker || message.kind == stderrMarker {
+			deliver(message)
+			continue
+		}
+
+		limiter.add(message, deliver)
+		if !limited && limiter.err() != nil {
+			limited = true
+			shell.abort(limiter.err(), done)
 		}
 	}
 
-	status, err := strconv.Atoi(result)
+	limiter.flush(deliver)
+
+	code, err := strconv.Atoi(status)
 	if err != nil {
-		return -1, err
+		return Result{Status: -1}, &ProtocolError{
+			Reason: fmt.Sprintf("malformed exit status %q", status),
+		}
+	}
+
+	result := Result{
+		Status:    Status(code),
+		Truncated: truncated || limiter.truncated,
 	}
 
-	return status, handlerErr
+	if handlerErr != nil {
+		return result, handlerErr
+	}
+
+	return result, limiter.err()
 }
 
-func (shell *shell) close() error {
-	shell.closed = true
+// abort interrupts the running command when the handler failed or the output
+// limit is exceeded and the shell is configured so; the output is still
+// drained up to the exit status
+func (shell *shell) abort(err error, done <-chan struct{}) {
+	if shell.interrupt {
+		go shell.interruptCommand(err, done)
+	}
+}
 
-	_, err := shell.stdin.Write([]byte("exit"))
-	if err != nil {
-		return err
+// interruptCommand signals the command until done is closed, since the
+// process which has to be interrupted may be not started yet
+func (shell *shell) interruptCommand(err error, done <-chan struct{}) {
+	if shell.signal == nil {
+		return
 	}
 
-	stdinErr := shell.stdin.Close()
-	stderrErr := shell.stdout.Close()
-	stdoutErr := shell.stderr.Close()
+	shell.log.Info("shell: interrupting command", "err", err)
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
 
-	close(shell.messages)
+		if err := shell.signal(syscall.SIGINT); err != nil {
+			shell.log.Warn("shell: interrupt failed", "err", err)
+		}
 
-	if stdinErr != nil {
-		return stdinErr
+		select {
+		case <-done:
+			return
+		case <-time.After(interruptInterval):
+		}
 	}
+}
 
-	if stderrErr != nil {
-		return stderrErr
+func (shell *shell) ExitStatus() int {
+	select {
+	case <-shell.exited:
+		return shell.status
+	default:
+		return -1
 	}
+}
+
+func (shell *shell) close() error {
+	shell.closeOnce.Do(func() {
+		close(shell.closing)
+
+		// interpreter may be already gone, terminate reports how it exited
+		shell.stdin.Write([]byte(shell.cleanup() + "exit\n"))
+		stdinErr := shell.stdin.Close()
+
+		terminate := shell.terminate
+		if terminate == nil {
+			terminate = shell.closeOutput
+		}
+
+		terminateErr := terminate()
+
+		shell.readers.Wait()
+		queueErr := shell.messages.close()
+
+		// exec closes stdin itself once the interpreter exits
+		if stdinErr != nil && !errors.Is(stdinErr, os.ErrClosed) {
+			shell.closeErr = stdinErr
+		} else if terminateErr != nil {
+			shell.closeErr = terminateErr
+		} else {
+			shell.closeErr = queueErr
+		}
+
+		if shell.closeErr != nil {
+			shell.log.Error("shell: close failed", "err", shell.closeErr)
+		}
+
+		shell.log.Info("shell: session closed", "status", shell.ExitStatus())
+	})
+
+	return shell.closeErr
+}
+
+func (shell *shell) closeOutput() error {
+	stdoutErr := shell.stdout.Close()
+	stderrErr := shell.stderr.Close()
 
 	if stdoutErr != nil {
 		return stdoutErr
 	}
 
-	return nil
+	return stderrErr
 }
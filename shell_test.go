@@ -1,9 +1,15 @@
 package shell
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"io"
+	"log/slog"
+	"os"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -14,7 +20,15 @@ type testShellResult struct {
 }
 
 func newTestShellState(limit int) testShellState {
-	shell := &shell{messages: make(chan message, 4096), limit: limit}
+	return newConfiguredTestShellState(limit, func(*shell) {})
+}
+
+func newConfiguredTestShellState(
+	limit int,
+	configure func(shell *shell),
+) testShellState {
+	shell := &shell{messages: newQueue(0, ""), limit: limit}
+	configure(shell)
 	state := testShellState{result: make(chan testShellResult, 1024)}
 
 	state.stdin, shell.stdin = io.Pipe()
@@ -87,7 +101,7 @@ func TestShellSendsCommandToStdin(test *testing.T) {
 		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_0__"))
 	})
 
-	expected := "COMMAND\necho -n __SHELL_EXIT_STATUS_$?__ | tee /dev/stderr\n"
+	expected := "COMMAND\n" + epilogue
 	assert.Equal(test, expected, string(result[:length]))
 }
 
@@ -301,3 +315,214 @@ func TestShellReturnsErrorIfHandlerReturnsError(test *testing.T) {
 
 	assert.Error(test, err)
 }
+
+func TestShellProbeSucceedsOnValidStatus(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	go func() {
+		state.stdin.Read(make([]byte, 1024))
+		go io.Copy(io.Discard, state.stdin)
+		state.stdout.Write([]byte("__SHELL_EXIT_STATUS_3__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_3__"))
+	}()
+
+	err := state.shell.probe(time.Second)
+	assert.NoError(test, err)
+}
+
+func TestShellProbeReturnsErrorOnUnexpectedStatus(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	go func() {
+		state.stdin.Read(make([]byte, 1024))
+		go io.Copy(io.Discard, state.stdin)
+		state.stdout.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+	}()
+
+	err := state.shell.probe(time.Second)
+	assert.ErrorIs(test, err, ErrUnsupportedShell)
+}
+
+func TestShellProbeReturnsErrorOnTimeout(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	go io.Copy(io.Discard, state.stdin)
+
+	err := state.shell.probe(10 * time.Millisecond)
+	assert.ErrorIs(test, err, ErrUnsupportedShell)
+}
+
+func TestShellReturnsSyntaxErrorOnIncompleteCommand(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+	go io.Copy(io.Discard, state.stdin)
+
+	status, err := state.shell.Run(`echo "TEST`, state.handler)
+	assert.Equal(test, -1, status)
+	assert.ErrorAs(test, err, new(*SyntaxError))
+}
+
+func TestShellCloseIsIdempotent(test *testing.T) {
+	state := newTestShellState(0)
+	go io.Copy(io.Discard, state.stdin)
+
+	errs := make(chan error, 10)
+	for index := 0; index < 10; index++ {
+		go func() { errs <- state.shell.close() }()
+	}
+
+	for index := 0; index < 10; index++ {
+		assert.NoError(test, <-errs)
+	}
+}
+
+func TestShellReturnsErrClosedOnRunningCommand(test *testing.T) {
+	state := newTestShellState(0)
+	go io.Copy(io.Discard, state.stdin)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := state.shell.Run("COMMAND", state.handler)
+		result <- err
+	}()
+
+	state.stdout.Write([]byte("OUTPUT\n"))
+	state.shell.close()
+	assert.ErrorIs(test, <-result, ErrClosed)
+}
+
+func TestShellReturnsErrClosedAfterClose(test *testing.T) {
+	state := newTestShellState(0)
+	go io.Copy(io.Discard, state.stdin)
+	state.shell.close()
+
+	_, err := state.shell.Run("COMMAND", state.handler)
+	assert.ErrorIs(test, err, ErrClosed)
+}
+
+func TestShellClosesWhileReadersAreBlocked(test *testing.T) {
+	for index := 0; index < 20; index++ {
+		state := newConfiguredTestShellState(0, func(shell *shell) {
+			shell.messages = newQueue(1, "")
+		})
+
+		go io.Copy(io.Discard, state.stdin)
+
+		go func() {
+			for {
+				if _, err := state.stdout.Write([]byte("LINE\n")); err != nil {
+					break
+				}
+			}
+		}()
+
+		assert.NoError(test, state.shell.close())
+	}
+}
+
+func TestShellPingSucceeds(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	go func() {
+		state.stdin.Read(make([]byte, 1024))
+		go io.Copy(io.Discard, state.stdin)
+		state.stdout.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+	}()
+
+	err := state.shell.Ping(context.Background())
+	assert.NoError(test, err)
+	assert.True(test, state.shell.Alive())
+}
+
+func TestShellPingReturnsErrorOnTimeout(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+	go io.Copy(io.Discard, state.stdin)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := state.shell.Ping(ctx)
+	assert.ErrorIs(test, err, context.DeadlineExceeded)
+}
+
+func TestShellIsNotAliveAfterReadError(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+	go io.Copy(io.Discard, state.stdin)
+
+	state.stdout.(*io.PipeWriter).CloseWithError(errors.New("ERROR"))
+	for index := 0; index < 100 && state.shell.Alive(); index++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.False(test, state.shell.Alive())
+}
+
+func TestShellIsNotAliveAfterClose(test *testing.T) {
+	state := newTestShellState(0)
+	go io.Copy(io.Discard, state.stdin)
+	state.shell.close()
+
+	assert.False(test, state.shell.Alive())
+}
+
+func TestShellLogsCommandsAndReads(test *testing.T) {
+	output := &bytes.Buffer{}
+	state := newConfiguredTestShellState(0, func(shell *shell) {
+		shell.log = slog.New(slog.NewTextHandler(
+			output,
+			&slog.HandlerOptions{Level: slog.LevelDebug},
+		))
+
+		shell.redaction = newRedactor([]string{"TOKEN"}, nil)
+	})
+
+	state.run("echo TOKEN", func() {
+		state.stdout.Write([]byte("TO"))
+		state.stdout.Write([]byte("KEN\n__SHELL_EXIT_STATUS_0__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_0__"))
+	})
+
+	state.shell.close()
+
+	log := output.String()
+	assert.Contains(test, log, `msg="shell: command started" id=1 command="echo ***"`)
+	assert.Contains(test, log, `msg="shell: read" stream=stdout`)
+	assert.Contains(test, log, `msg="shell: exit status detected"`)
+	assert.Contains(test, log, `msg="shell: command finished" id=1 status=0`)
+	assert.Contains(test, log, `msg="shell: session closed"`)
+	assert.NotContains(test, log, "TO")
+}
+
+func TestShellInterruptsCommandOnHandlerError(test *testing.T) {
+	signals := make(chan os.Signal, 1024)
+	state := newConfiguredTestShellState(0, func(shell *shell) {
+		shell.interrupt = true
+		shell.signal = func(signal os.Signal) error {
+			signals <- signal
+			return nil
+		}
+	})
+
+	defer state.shell.close()
+
+	handlerErr := errors.New("handler failed")
+	state.err = handlerErr
+	status, err := state.run("COMMAND", func() {
+		state.stdout.Write([]byte("1\n2\n"))
+		assert.Equal(test, syscall.SIGINT, <-signals)
+		state.stdout.Write([]byte("__SHELL_EXIT_STATUS_130__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_130__"))
+	})
+
+	assert.Equal(test, handlerErr, err)
+	assert.Equal(test, 130, status)
+	assert.Equal(test, []string{"OUT: 1"}, state.args)
+}
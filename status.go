@@ -0,0 +1,106 @@
+package shell
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Status is the status reported by the interpreter for a command; -1
+// means that the command did not finish and there is no status
+type Status int
+
+const (
+	StatusNotExecutable Status = 126
+	StatusNotFound      Status = 127
+
+	signalOffset = 128
+	signalMax    = 64
+)
+
+func (status Status) Success() bool {
+	return status == 0
+}
+
+func (status Status) NotFound() bool {
+	return status == StatusNotFound
+}
+
+func (status Status) NotExecutable() bool {
+	return status == StatusNotExecutable
+}
+
+// Signaled tells whether the status is the one the interpreter reports for
+// a command killed by a signal; a command may exit with it on its own as well
+func (status Status) Signaled() bool {
+	return status > signalOffset && status <= signalOffset+signalMax
+}
+
+func (status Status) Signal() syscall.Signal {
+	if !status.Signaled() {
+		return 0
+	}
+
+	return syscall.Signal(status - signalOffset)
+}
+
+// Err returns *ExitError for a failed command and nil otherwise
+func (status Status) Err() error {
+	if status == 0 {
+		return nil
+	}
+
+	return &ExitError{Status: status}
+}
+
+func (status Status) String() string {
+	switch {
+	case status < 0:
+		return "no exit status"
+	case status.NotFound():
+		return "exit status 127: command not found"
+	case status.NotExecutable():
+		return "exit status 126: command not executable"
+	case status.Signaled():
+		return fmt.Sprintf(
+			"exit status %d: killed by signal %d (%s)",
+			int(status),
+			int(status.Signal()),
+			status.Signal(),
+		)
+	}
+
+	return fmt.Sprintf("exit status %d", int(status))
+}
+
+// ExitError is a command failure; the session stays usable
+type ExitError struct {
+	Status Status
+}
+
+func (err *ExitError) Error() string {
+	return "shell: " + err.Status.String()
+}
+
+// ProtocolError is returned when the interpreter output can not be
+// understood, for example when the exit status is malformed
+type ProtocolError struct {
+	Reason string
+}
+
+func (err *ProtocolError) Error() string {
+	return "shell: protocol error: " + err.Reason
+}
+
+// TransportError is a failure to write to or read from the interpreter; the
+// session is most likely broken
+type TransportError struct {
+	Err error
+}
+
+func (err *TransportError) Error() string {
+	return "shell: transport error: " + err.Err.Error()
+}
+
+func (err *TransportError) Unwrap() error {
+	return err.Err
+}
@@ -0,0 +1,84 @@
+package shell
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusDecodesFailures(test *testing.T) {
+	assert.True(test, Status(0).Success())
+	assert.Nil(test, Status(0).Err())
+
+	assert.True(test, Status(127).NotFound())
+	assert.Equal(test, "exit status 127: command not found", Status(127).String())
+
+	assert.True(test, Status(126).NotExecutable())
+	assert.False(test, Status(126).Signaled())
+
+	assert.True(test, Status(137).Signaled())
+	assert.Equal(test, syscall.SIGKILL, Status(137).Signal())
+	assert.Equal(
+		test,
+		"exit status 137: killed by signal 9 (killed)",
+		Status(137).String(),
+	)
+
+	assert.False(test, Status(3).Signaled())
+	assert.Equal(test, syscall.Signal(0), Status(3).Signal())
+	assert.Equal(test, "no exit status", Status(-1).String())
+}
+
+func TestStatusErrIsExitError(test *testing.T) {
+	var exitErr *ExitError
+	assert.ErrorAs(test, Status(2).Err(), &exitErr)
+	assert.Equal(test, Status(2), exitErr.Status)
+	assert.EqualError(test, exitErr, "shell: exit status 2")
+}
+
+func TestShellReturnsProtocolErrorOnMalformedStatus(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	go io.Copy(io.Discard, state.stdin)
+	go func() {
+		state.stdout.Write([]byte("__SHELL_EXIT_STATUS_X__"))
+		state.stderr.Write([]byte("__SHELL_EXIT_STATUS_X__"))
+	}()
+
+	result, err := state.shell.Exec("COMMAND", nil)
+	assert.Equal(test, Status(-1), result.Status)
+
+	var protocolErr *ProtocolError
+	assert.ErrorAs(test, err, &protocolErr)
+	assert.Equal(test, `malformed exit status "X"`, protocolErr.Reason)
+}
+
+func TestShellReturnsTransportErrorOnWriteFailure(test *testing.T) {
+	state := newTestShellState(0)
+	defer state.shell.close()
+
+	state.stdin.Close()
+	_, err := state.shell.Exec("COMMAND", nil)
+
+	var transportErr *TransportError
+	assert.ErrorAs(test, err, &transportErr)
+	assert.True(test, errors.Is(err, io.ErrClosedPipe))
+}
+
+func TestLocalReportsCommandFailures(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	result, err := state.shell.Exec("nonexistent-command 2> /dev/null", nil)
+	assert.NoError(test, err)
+	assert.True(test, result.Status.NotFound())
+
+	result, err = state.shell.Exec("sh -c 'kill -TERM $$'", nil)
+	assert.NoError(test, err)
+	assert.True(test, result.Status.Signaled())
+	assert.Equal(test, syscall.SIGTERM, result.Status.Signal())
+}
@@ -0,0 +1,206 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+)
+
+type BecomeMethod string
+
+const (
+	Sudo BecomeMethod = "sudo"
+	Su   BecomeMethod = "su"
+
+	becomeFailed = "__SHELL_BECOME_FAILED__"
+)
+
+var (
+	ErrSuPassword = errors.New("shell: su can not read password without " +
+		"terminal, use sudo")
+)
+
+type PasswordError struct {
+	User string
+}
+
+func (err *PasswordError) Error() string {
+	return "shell: failed to authenticate to become " + err.User
+}
+
+func (shell *shell) RunAs(
+	user string,
+	command string,
+	handler func(MessageType, string) error,
+) (int, error) {
+	if err := Validate(command); err != nil {
+		return -1, shell.redaction.error(err)
+	}
+
+	argv := append(append([]string{}, shell.interpreter...), "-c", command)
+	check, privileged, password, err := shell.escalation(user, argv)
+	if err != nil {
+		return -1, err
+	}
+
+	// the query carries the password, which the interpreter may echo back
+	// when it traces its input
+	defer shell.redaction.with(password)()
+
+	failed := false
+	query := guarded(builtin(check, password), privileged)
+	result, err := shell.execute(
+		command,
+		query+"\n"+epilogue,
+		authenticated(&failed, handler),
+		nil,
+	)
+
+	if failed {
+		return -1, &PasswordError{User: user}
+	}
+
+	return int(result.Status), err
+}
+
+// the new interpreter replaces the current one and reports the status of the
+// query itself, because the epilogue written along with the query is already
+// consumed by the current one
+func (shell *shell) become(user string) error {
+	started := "printf '__SHELL_EXIT_STATUS_0__'; " +
+		"printf '__SHELL_EXIT_STATUS_0__' 1>&2; "
+
+	argv := []string{
+		shell.interpreter[0],
+		"-c",
+		started + "exec " + Quote(shell.interpreter...),
+	}
+
+	check, command, password, err := shell.escalation(user, argv)
+	if err != nil {
+		return err
+	}
+
+	defer shell.redaction.with(password)()
+
+	failed := false
+	query := guarded(builtin(check, password), "exec "+command) + "; " + epilogue
+	result, err := shell.execute(
+		"exec "+Quote(shell.interpreter...),
+		query,
+		authenticated(&failed, nil),
+		nil,
+	)
+
+	if failed {
+		return &PasswordError{User: user}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if result.Status != 0 {
+		return fmt.Errorf(
+			"shell: failed to become %s, status %d",
+			user,
+			result.Status,
+		)
+	}
+
+	shell.user = user
+	shell.pid, err = shell.processID()
+	return err
+}
+
+func authenticated(
+	failed *bool,
+	handler func(MessageType, string) error,
+) func(MessageType, string) error {
+	return func(kind MessageType, line string) error {
+		if kind == StdErr && line == becomeFailed {
+			*failed = true
+			return nil
+		}
+
+		if handler == nil {
+			return nil
+		}
+
+		return handler(kind, line)
+	}
+}
+
+// privileged returns a command run outside of the interpreter and the input
+// it reads; the password is passed in the input so it is never seen in the
+// arguments of a process or in the command itself
+func (shell *shell) privileged(
+	user string,
+	argv []string,
+) (string, string, error) {
+	check, command, password, err := shell.escalation(user, argv)
+	if err != nil {
+		return "", "", err
+	}
+
+	input := ""
+	if password != "" {
+		input = password + "\n"
+	}
+
+	return guarded(check, command), input, nil
+}
+
+// builtin feeds password to check from the interpreter which runs the query;
+// printf is a builtin so the password is not seen in the arguments of a process
+func builtin(check string, password string) string {
+	if password == "" {
+		return check
+	}
+
+	return "printf '%s\\n' " + Quote(password) + " | " + check
+}
+
+// credentials are checked in a separate step so that a password is never
+// left on the stdin of the command itself and a failure can be told apart
+// from the command exit status
+func guarded(check string, command string) string {
+	return "{ " + check + " 2> /dev/null || " +
+		"{ printf '%s\\n' " + becomeFailed + " 1>&2; false; }; } && " +
+		command
+}
+
+// escalation returns the credentials check, the command and the password
+// the check reads from stdin, if any
+func (shell *shell) escalation(
+	user string,
+	argv []string,
+) (string, string, string, error) {
+	switch shell.method {
+	case Su:
+		if shell.password != nil {
+			return "", "", "", ErrSuPassword
+		}
+
+		check := Quote("su", "-s", defaultShell, user, "-c", "true") +
+			" < /dev/null"
+		command := Quote("su", "-s", argv[0], user) + " " + Quote(argv[1:]...)
+		return check, command, "", nil
+	case Sudo, "":
+		check := "sudo -n -v"
+		password := ""
+		if shell.password != nil {
+			var err error
+			if password, err = shell.password(); err != nil {
+				return "", "", "", err
+			}
+
+			check = "sudo -S -p '' -v"
+		}
+
+		command := Quote("sudo", "-n", "-u", user, "--") + " " + Quote(argv...)
+		return check, command, password, nil
+	default:
+		return "", "", "", errors.New("shell: unknown become method " +
+			string(shell.method))
+	}
+}
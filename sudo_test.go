@@ -0,0 +1,225 @@
+package shell
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func skipUnlessSu(test *testing.T) {
+	if _, err := exec.LookPath("su"); err != nil || os.Geteuid() != 0 {
+		test.Skip("su without password requires root")
+	}
+}
+
+func TestSudoPrivilegedChecksCredentialsBeforeCommand(test *testing.T) {
+	shell := &shell{}
+	query, input, err := shell.privileged("admin", []string{"/bin/sh", "-c", "id"})
+	assert.NoError(test, err)
+	assert.Empty(test, input)
+
+	expected := "{ sudo -n -v 2> /dev/null || " +
+		"{ printf '%s\\n' __SHELL_BECOME_FAILED__ 1>&2; false; }; } && " +
+		"sudo -n -u admin -- /bin/sh -c id"
+	assert.Equal(test, expected, query)
+}
+
+func TestSudoPrivilegedPassesPasswordToSudo(test *testing.T) {
+	shell := &shell{password: func() (string, error) {
+		return "it's secret", nil
+	}}
+
+	query, input, err := shell.privileged("admin", []string{"id"})
+	assert.NoError(test, err)
+	assert.Contains(test, query, "{ sudo -S -p '' -v 2> /dev/null ||")
+	assert.NotContains(test, query, "secret")
+	assert.Equal(test, "it's secret\n", input)
+}
+
+func TestSudoBuiltinFeedsPasswordFromInterpreter(test *testing.T) {
+	assert.Equal(test, "sudo -n -v", builtin("sudo -n -v", ""))
+	assert.Equal(
+		test,
+		"printf '%s\\n' 'it'\\''s secret' | sudo -S -p '' -v",
+		builtin("sudo -S -p '' -v", "it's secret"),
+	)
+}
+
+func TestSudoPrivilegedReturnsPasswordCallbackError(test *testing.T) {
+	expected := errors.New("no password")
+	shell := &shell{password: func() (string, error) {
+		return "", expected
+	}}
+
+	_, _, err := shell.privileged("admin", []string{"id"})
+	assert.ErrorIs(test, err, expected)
+}
+
+func TestSudoPrivilegedUsesSu(test *testing.T) {
+	shell := &shell{method: Su}
+	query, _, err := shell.privileged("nobody", []string{"/bin/sh", "-c", "id"})
+	assert.NoError(test, err)
+	assert.Contains(test, query, "su -s /bin/sh nobody -c true < /dev/null")
+	assert.Contains(test, query, "&& su -s /bin/sh nobody -c id")
+}
+
+func TestSudoPrivilegedRejectsPasswordForSu(test *testing.T) {
+	shell := &shell{method: Su, password: func() (string, error) {
+		return "secret", nil
+	}}
+
+	_, _, err := shell.privileged("nobody", []string{"id"})
+	assert.ErrorIs(test, err, ErrSuPassword)
+}
+
+func TestSudoRunAsReturnsPasswordError(test *testing.T) {
+	state := newTestShellState(0)
+	state.shell.interpreter = []string{"/bin/sh"}
+	defer state.shell.close()
+
+	var status int
+	var err error
+	done := make(chan struct{})
+	go func() {
+		status, err = state.shell.RunAs("admin", "id", state.handler)
+		close(done)
+	}()
+
+	go func() {
+		bytes := make([]byte, 1024)
+		for {
+			if _, err := state.stdin.Read(bytes); err != nil {
+				break
+			}
+		}
+	}()
+
+	state.stderr.Write([]byte(becomeFailed + "\n__SHELL_EXIT_STATUS_1__"))
+	state.stdout.Write([]byte("__SHELL_EXIT_STATUS_1__"))
+	<-done
+
+	var passwordErr *PasswordError
+	assert.ErrorAs(test, err, &passwordErr)
+	assert.Equal(test, "admin", passwordErr.User)
+	assert.Equal(test, -1, status)
+	assert.Empty(test, state.args)
+}
+
+func TestSudoRunAsRunsCommandAsUser(test *testing.T) {
+	skipUnlessSu(test)
+
+	shell, err := NewLocal(LocalConfig{BecomeMethod: Su})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	output := []string{}
+	status, err := shell.RunAs("nobody", "id -un", func(
+		kind MessageType,
+		line string,
+	) error {
+		output = append(output, line)
+		return nil
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"nobody"}, output)
+}
+
+func TestSudoRunAsReturnsCommandStatus(test *testing.T) {
+	skipUnlessSu(test)
+
+	shell, err := NewLocal(LocalConfig{BecomeMethod: Su})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	status, err := shell.RunAs("nobody", "exit 3", nil)
+	assert.NoError(test, err)
+	assert.Equal(test, 3, status)
+}
+
+func TestSudoBecomesUserForSession(test *testing.T) {
+	skipUnlessSu(test)
+
+	shell, err := NewLocal(LocalConfig{Become: "nobody", BecomeMethod: Su})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	output := []string{}
+	handler := func(kind MessageType, line string) error {
+		output = append(output, line)
+		return nil
+	}
+
+	status, err := shell.Run("id -un; cd /tmp", handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+
+	status, err = shell.Run("id -un; pwd", handler)
+	assert.NoError(test, err)
+	assert.Equal(test, 0, status)
+	assert.Equal(test, []string{"nobody", "nobody", "/tmp"}, output)
+}
+
+func TestSudoSignalsCommandOfBecomeUser(test *testing.T) {
+	skipUnlessSu(test)
+
+	shell, err := NewLocal(LocalConfig{Become: "nobody", BecomeMethod: Su})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	go func() {
+		for {
+			pids, _ := descendants(shell.pid)
+			if len(pids) > 0 {
+				break
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		shell.Signal(syscall.SIGINT)
+	}()
+
+	status, err := shell.Run("sleep 100", nil)
+	assert.NoError(test, err)
+	assert.Equal(test, 130, status)
+}
+
+func TestSudoClosesBecomeSession(test *testing.T) {
+	skipUnlessSu(test)
+
+	shell, err := NewLocal(LocalConfig{Become: "nobody", BecomeMethod: Su})
+	assert.NoError(test, err)
+
+	assert.NoError(test, shell.Close())
+	assert.False(test, shell.Alive())
+}
+
+func TestSudoRunAsRedactsPasswordEchoedByInterpreter(test *testing.T) {
+	shell, err := NewLocal(LocalConfig{
+		Args:     []string{"-v"},
+		Password: func() (string, error) { return "hunter2", nil },
+	})
+	assert.NoError(test, err)
+	defer shell.Close()
+
+	output := []string{}
+	shell.RunAs("admin", "id", func(kind MessageType, line string) error {
+		output = append(output, line)
+		return nil
+	})
+
+	assert.NotEmpty(test, output)
+	for _, line := range output {
+		assert.NotContains(test, line, "hunter2")
+	}
+
+	assert.Contains(test, strings.Join(output, "\n"), "printf '%s\\n' ***")
+}
@@ -0,0 +1,175 @@
+package shell
+
+import (
+	"fmt"
+)
+
+type TaskState string
+
+const (
+	TaskChanged   TaskState = "changed"
+	TaskUnchanged TaskState = "unchanged"
+	TaskFailed    TaskState = "failed"
+	TaskSkipped   TaskState = "skipped"
+)
+
+// Task runs Action unless Unless command succeeds or Creates path exists;
+// Requires lists names of tasks which have to succeed first
+type Task struct {
+	Name     string
+	Action   string
+	Unless   string
+	Creates  string
+	Requires []string
+}
+
+type TaskResult struct {
+	Name   string
+	State  TaskState
+	Status int
+	Output []Line
+	Err    error
+}
+
+// RunTasks runs tasks in dependency order keeping the given order between
+// independent ones; tasks depending on a failed or skipped task are skipped
+func RunTasks(shell Shell, tasks []Task) ([]TaskResult, error) {
+	order, err := taskOrder(tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	states := map[string]TaskState{}
+	results := []TaskResult{}
+	for _, index := range order {
+		task := tasks[index]
+
+		result := TaskResult{Name: task.Name, State: TaskSkipped, Status: -1}
+		for _, name := range task.Requires {
+			if states[name] == TaskFailed || states[name] == TaskSkipped {
+				result.Err = fmt.Errorf("shell: task %q requires %q", task.Name, name)
+			}
+		}
+
+		if result.Err == nil {
+			result = runTask(shell, task)
+		}
+
+		states[task.Name] = result.State
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func runTask(shell Shell, task Task) TaskResult {
+	result := TaskResult{Name: task.Name, State: TaskFailed, Status: -1}
+
+	guards := []string{}
+	if task.Unless != "" {
+		guards = append(guards, task.Unless)
+	}
+
+	if task.Creates != "" {
+		guards = append(guards, Quote("test", "-e", task.Creates))
+	}
+
+	for _, guard := range guards {
+		status, err := shell.Run(guard, nil)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+
+		if status == 0 {
+			result.State = TaskUnchanged
+			result.Status = 0
+			return result
+		}
+	}
+
+	status, err := shell.Run(task.Action, func(kind MessageType, line string) error {
+		result.Output = append(result.Output, Line{kind, line})
+		return nil
+	})
+
+	result.Status = status
+	switch {
+	case err != nil:
+		result.Err = err
+	case status != 0:
+		result.Err = fmt.Errorf(
+			"shell: task %q failed with status %d",
+			task.Name,
+			status,
+		)
+	default:
+		result.State = TaskChanged
+	}
+
+	return result
+}
+
+func taskOrder(tasks []Task) ([]int, error) {
+	indexes := map[string]int{}
+	for index, task := range tasks {
+		if _, ok := indexes[task.Name]; ok {
+			return nil, fmt.Errorf("shell: duplicate task %q", task.Name)
+		}
+
+		indexes[task.Name] = index
+	}
+
+	for _, task := range tasks {
+		for _, name := range task.Requires {
+			if _, ok := indexes[name]; !ok {
+				return nil, fmt.Errorf(
+					"shell: task %q requires unknown task %q",
+					task.Name,
+					name,
+				)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	marks := make([]int, len(tasks))
+	order := []int{}
+
+	var visit func(index int) error
+	visit = func(index int) error {
+		switch marks[index] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf(
+				"shell: task %q has circular dependency",
+				tasks[index].Name,
+			)
+		}
+
+		marks[index] = visiting
+		for _, name := range tasks[index].Requires {
+			if err := visit(indexes[name]); err != nil {
+				return err
+			}
+		}
+
+		marks[index] = visited
+		order = append(order, index)
+		return nil
+	}
+
+	for index := range tasks {
+		if err := visit(index); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
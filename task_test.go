@@ -0,0 +1,90 @@
+package shell
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTasksRunInDependencyOrder(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	results, err := RunTasks(state.shell, []Task{
+		{Name: "third", Action: "echo 3", Requires: []string{"second"}},
+		{Name: "first", Action: "echo 1"},
+		{Name: "second", Action: "echo 2", Requires: []string{"first"}},
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, []TaskResult{
+		{"first", TaskChanged, 0, []Line{{StdOut, "1"}}, nil},
+		{"second", TaskChanged, 0, []Line{{StdOut, "2"}}, nil},
+		{"third", TaskChanged, 0, []Line{{StdOut, "3"}}, nil},
+	}, results)
+}
+
+func TestTasksAreIdempotent(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	directory := test.TempDir()
+	path := filepath.Join(directory, "created")
+	tasks := []Task{
+		{Name: "create", Action: Quote("touch", path), Creates: path},
+		{
+			Name:   "append",
+			Action: "echo LINE >> " + Quote(path),
+			Unless: Quote("grep", "-q", "LINE", path),
+		},
+	}
+
+	results, err := RunTasks(state.shell, tasks)
+	assert.NoError(test, err)
+	assert.Equal(test, TaskChanged, results[0].State)
+	assert.Equal(test, TaskChanged, results[1].State)
+
+	results, err = RunTasks(state.shell, tasks)
+	assert.NoError(test, err)
+	assert.Equal(test, TaskUnchanged, results[0].State)
+	assert.Equal(test, TaskUnchanged, results[1].State)
+}
+
+func TestTasksSkipDependentsOfFailedTask(test *testing.T) {
+	state := newTestLocalState()
+	defer state.shell.Close()
+
+	results, err := RunTasks(state.shell, []Task{
+		{Name: "broken", Action: "echo FAILED 1>&2; (exit 2)"},
+		{Name: "dependent", Action: "echo NEVER", Requires: []string{"broken"}},
+		{Name: "independent", Action: "true"},
+	})
+
+	assert.NoError(test, err)
+	assert.Equal(test, TaskFailed, results[0].State)
+	assert.Equal(test, 2, results[0].Status)
+	assert.Equal(test, []Line{{StdErr, "FAILED"}}, results[0].Output)
+	assert.EqualError(test, results[0].Err, `shell: task "broken" failed with status 2`)
+
+	assert.Equal(test, TaskSkipped, results[1].State)
+	assert.Error(test, results[1].Err)
+	assert.Empty(test, results[1].Output)
+
+	assert.Equal(test, TaskChanged, results[2].State)
+}
+
+func TestTasksRejectInvalidDependencies(test *testing.T) {
+	_, err := RunTasks(nil, []Task{{Name: "a"}, {Name: "a"}})
+	assert.EqualError(test, err, `shell: duplicate task "a"`)
+
+	_, err = RunTasks(nil, []Task{{Name: "a", Requires: []string{"b"}}})
+	assert.EqualError(test, err, `shell: task "a" requires unknown task "b"`)
+
+	_, err = RunTasks(nil, []Task{
+		{Name: "a", Requires: []string{"b"}},
+		{Name: "b", Requires: []string{"a"}},
+	})
+
+	assert.EqualError(test, err, `shell: task "a" has circular dependency`)
+}
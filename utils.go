@@ -6,6 +6,25 @@ var (
 	escape = regexp.MustCompile(`[^\w/]`)
 )
 
+// Deprecated: use Quote, it handles any input including newlines.
 func Escape(argument string) string {
-	return escape.ReplaceAllString(argument, `\$0`)
+	if argument == "" {
+		return "''"
+	}
+
+	return escape.ReplaceAllStringFunc(argument, func(char string) string {
+		if char == "\n" {
+			return "'\n'"
+		}
+
+		return `\` + char
+	})
+}
+
+func interpreter(shell string, args []string) []string {
+	if shell == "" {
+		shell = defaultShell
+	}
+
+	return append([]string{shell}, args...)
 }
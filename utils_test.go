@@ -15,3 +15,23 @@ func TestShellEscapeReturnsEscapedEval(test *testing.T) {
 	actual := Escape("test`eval`")
 	assert.Equal(test, "test\\`eval\\`", actual)
 }
+
+func TestShellInterpreterDefaultsToBinSh(test *testing.T) {
+	actual := interpreter("", nil)
+	assert.Equal(test, []string{"/bin/sh"}, actual)
+}
+
+func TestShellInterpreterAppendsArgs(test *testing.T) {
+	actual := interpreter("bash", []string{"-l"})
+	assert.Equal(test, []string{"bash", "-l"}, actual)
+}
+
+func TestShellEscapeReturnsQuotesOnEmptyValue(test *testing.T) {
+	actual := Escape("")
+	assert.Equal(test, "''", actual)
+}
+
+func TestShellEscapeQuotesNewline(test *testing.T) {
+	actual := Escape("a\nb")
+	assert.Equal(test, "a'\n'b", actual)
+}